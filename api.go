@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// messageRequest is the body of a POST to /messages. To is a node ID or number and defaults to broadcast
+type messageRequest struct {
+	Text    string      `json:"text"`
+	To      interface{} `json:"to"`
+	Channel uint32      `json:"channel"`
+	ReplyTo uint32      `json:"reply_to"`
+}
+
+// addAPIRoutes adds the JSON API for other programs to read the node database and send messages
+func addAPIRoutes(mux *http.ServeMux, s *meshSession) {
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		nodes := s.nodeSummaries()
+		sort.Slice(nodes, func(i, j int) bool { return nodes[i].Num < nodes[j].Num })
+		writeJSON(w, http.StatusOK, nodes)
+	})
+
+	mux.HandleFunc("/channels", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		channels := make([]json.RawMessage, 0)
+		for _, channel := range s.channelList() {
+			channels = append(channels, marshalProto(channel))
+		}
+		writeJSON(w, http.StatusOK, channels)
+	})
+
+	mux.HandleFunc("/config", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		configs, modules := s.radioConfig()
+		config := map[string][]json.RawMessage{"config": {}, "module_config": {}}
+		for _, c := range configs {
+			config["config"] = append(config["config"], marshalProto(c))
+		}
+		for _, m := range modules {
+			config["module_config"] = append(config["module_config"], marshalProto(m))
+		}
+		writeJSON(w, http.StatusOK, config)
+	})
+
+	mux.HandleFunc("/messages", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			since, err := strconv.ParseUint(r.URL.Query().Get("since"), 10, 64)
+			if err != nil && r.URL.Query().Get("since") != "" {
+				writeError(w, http.StatusBadRequest, errors.New("since must be a message sequence number"))
+				return
+			}
+			writeJSON(w, http.StatusOK, s.messagesSince(since))
+		case http.MethodPost:
+			request := messageRequest{}
+			if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			if request.Text == "" {
+				writeError(w, http.StatusBadRequest, errors.New("text is required"))
+				return
+			}
+			to, err := requestNode(request.To)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, err)
+				return
+			}
+			message, err := s.sendText(request.Text, to, request.Channel, request.ReplyTo)
+			if err != nil {
+				writeError(w, http.StatusBadGateway, err)
+				return
+			}
+			writeJSON(w, http.StatusAccepted, message)
+		default:
+			allowMethod(w, r, http.MethodGet, http.MethodPost)
+		}
+	})
+}
+
+// requestNode converts the node in a request body to a node number. Nodes can be given as a node number
+// or any string accepted by parseNodeID, and broadcast is used if there isn't one
+func requestNode(value interface{}) (uint32, error) {
+	switch node := value.(type) {
+	case nil:
+		return broadcastNum, nil
+	case float64:
+		if node < 0 || node > broadcastNum || node != float64(uint32(node)) {
+			return 0, fmt.Errorf("invalid node number %v", node)
+		}
+		return uint32(node), nil
+	case string:
+		return parseNodeID(node)
+	}
+
+	return 0, fmt.Errorf("invalid node %v", value)
+}
+
+// allowMethod responds with 405 Method Not Allowed unless the request uses one of the methods
+func allowMethod(w http.ResponseWriter, r *http.Request, methods ...string) bool {
+	for _, method := range methods {
+		if r.Method == method {
+			return true
+		}
+	}
+
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+
+	return false
+}
+
+// marshalProto encodes a protobuf message using the standard protobuf JSON mapping
+func marshalProto(m proto.Message) json.RawMessage {
+	out, err := protojson.Marshal(m)
+	if err != nil {
+		return json.RawMessage("null")
+	}
+
+	return json.RawMessage(out)
+}
+
+// writeError writes an error as a JSON response
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -2,15 +2,27 @@ package main
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
 
+	"github.com/lmatte7/gomesh"
 	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
 	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protowire"
 	"google.golang.org/protobuf/proto"
 )
 
+// channelURLPrefix is the prefix the meshtastic apps use for channel set URLs
+const channelURLPrefix = "https://meshtastic.org/e/#"
+
+// maxChannels is the number of channel slots available on the radio
+const maxChannels = 8
+
 func showChannelInfo(c *cli.Context) error {
 	radio := getRadio(c)
 	defer radio.Close()
@@ -20,7 +32,12 @@ func showChannelInfo(c *cli.Context) error {
 		return cli.Exit(err, 0)
 	}
 
-	err = printChannels(channels)
+	loraConfig, err := getLoraConfig(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	err = printChannels(channels, loraConfig)
 	if err != nil {
 		return cli.Exit(err, 0)
 	}
@@ -28,11 +45,7 @@ func showChannelInfo(c *cli.Context) error {
 	return nil
 }
 
-func printChannels(channels []*gomeshproto.Channel) error {
-
-	primaryChannelSettings := gomeshproto.ChannelSettings{}
-	allChannelSettings := []*gomeshproto.ChannelSettings{}
-	channelSet := gomeshproto.ChannelSet{}
+func printChannels(channels []*gomeshproto.Channel, loraConfig *gomeshproto.Config_LoRaConfig) error {
 
 	fmt.Printf("%s", "\n")
 	fmt.Printf("Channel Settings:\n")
@@ -53,12 +66,6 @@ func printChannels(channels []*gomeshproto.Channel) error {
 			continue
 		}
 
-		if channelInfo.GetRole() == gomeshproto.Channel_PRIMARY {
-			primaryChannelSettings = *channelInfo.Settings
-		}
-
-		allChannelSettings = append(allChannelSettings, channelInfo.Settings)
-
 		if len(channelInfo.Settings.Name) > 0 {
 			fmt.Printf("| %-15s| ", channelInfo.Settings.Name)
 		} else {
@@ -105,29 +112,118 @@ func printChannels(channels []*gomeshproto.Channel) error {
 	}
 	printDoubleDivider()
 
-	channelSet.Settings = allChannelSettings
-
-	out, err := proto.Marshal(&primaryChannelSettings)
+	primaryURL, fullURL, err := channelURLs(channels, loraConfig)
 	if err != nil {
 		return cli.Exit("Error parsing channel URL", 0)
 	}
 
-	url := base64.RawURLEncoding.EncodeToString(out)
-
 	fmt.Printf("%-25s", "Primary Channel URL: ")
-	fmt.Printf("https://www.meshtastic.org/c/#%s\n", url)
+	fmt.Printf("%s\n", primaryURL)
+
+	fmt.Printf("%-25s", "Full Channel URL: ")
+	fmt.Printf("%s\n", fullURL)
 
-	out, err = proto.Marshal(&channelSet)
+	return nil
+}
+
+// channelURLs builds the primary and full meshtastic URLs for the enabled channels. Both URLs hold a
+// ChannelSet with the radio's LoRa config, the primary URL only includes the primary channel
+func channelURLs(channels []*gomeshproto.Channel, loraConfig *gomeshproto.Config_LoRaConfig) (primaryURL string, fullURL string, err error) {
+
+	primarySet := gomeshproto.ChannelSet{LoraConfig: loraConfig}
+	fullSet := gomeshproto.ChannelSet{LoraConfig: loraConfig}
+
+	for _, channelInfo := range channels {
+		if channelInfo.GetRole() == gomeshproto.Channel_DISABLED {
+			continue
+		}
+
+		if channelInfo.GetRole() == gomeshproto.Channel_PRIMARY {
+			primarySet.Settings = []*gomeshproto.ChannelSettings{channelInfo.Settings}
+			// The primary channel always comes first in a ChannelSet
+			fullSet.Settings = append([]*gomeshproto.ChannelSettings{channelInfo.Settings}, fullSet.Settings...)
+			continue
+		}
+
+		fullSet.Settings = append(fullSet.Settings, channelInfo.Settings)
+	}
+
+	primaryURL, err = encodeChannelURL(&primarySet)
 	if err != nil {
-		return cli.Exit("Error parsing channel URL", 0)
+		return "", "", err
+	}
+
+	fullURL, err = encodeChannelURL(&fullSet)
+	if err != nil {
+		return "", "", err
 	}
 
-	url = base64.RawURLEncoding.EncodeToString(out)
+	return primaryURL, fullURL, nil
+}
 
-	fmt.Printf("%-25s", "Full Channel URL: ")
-	fmt.Printf("https://www.meshtastic.org/c/#%s\n", url)
+// encodeChannelURL encodes a ChannelSet as a meshtastic channel URL
+func encodeChannelURL(channelSet *gomeshproto.ChannelSet) (string, error) {
+	out, err := proto.Marshal(channelSet)
+	if err != nil {
+		return "", err
+	}
 
-	return nil
+	return channelURLPrefix + base64.RawURLEncoding.EncodeToString(out), nil
+}
+
+// decodeChannelURL decodes a meshtastic channel URL into a ChannelSet. Both the current /e/ URLs and
+// the older /c/ URLs are accepted, with or without base64 padding. Older primary channel URLs hold a
+// single ChannelSettings rather than a ChannelSet, so those are returned as a set of one channel
+func decodeChannelURL(url string) (*gomeshproto.ChannelSet, error) {
+	split := strings.Split(url, "#")
+	encoded := split[len(split)-1]
+	encoded = strings.TrimRight(encoded, "=")
+	encoded = strings.NewReplacer("+", "-", "/", "_").Replace(encoded)
+
+	out, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	channelSet := gomeshproto.ChannelSet{}
+	if hasChannelSettings(out) {
+		if err := proto.Unmarshal(out, &channelSet); err != nil {
+			return nil, err
+		}
+	} else if len(out) > 0 {
+		settings := gomeshproto.ChannelSettings{}
+		if err := proto.Unmarshal(out, &settings); err != nil {
+			return nil, err
+		}
+		channelSet.Settings = []*gomeshproto.ChannelSettings{&settings}
+	}
+
+	if len(channelSet.Settings) == 0 {
+		return nil, errors.New("channel URL doesn't contain any channels")
+	}
+
+	return &channelSet, nil
+}
+
+// hasChannelSettings reports whether an encoded message is a ChannelSet, which has channel settings in
+// field 1. In a bare ChannelSettings field 1 is the channel number, which is a varint instead
+func hasChannelSettings(data []byte) bool {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return false
+		}
+		if num == 1 {
+			return typ == protowire.BytesType
+		}
+		size := protowire.ConsumeFieldValue(num, typ, data[n:])
+		if size < 0 {
+			return false
+		}
+		data = data[n+size:]
+	}
+
+	return false
 }
 
 func showChannelOptions(c *cli.Context) error {
@@ -200,7 +296,25 @@ func deleteChannel(c *cli.Context) error {
 	radio := getRadio(c)
 	defer radio.Close()
 
-	return radio.DeleteChannel(c.Int("index"))
+	index := c.Int("index")
+
+	// Deleting a channel shifts the channels after it down so the enabled channels stay contiguous
+	err := rearrangeChannels(radio, func(enabled []*gomeshproto.Channel) ([]*gomeshproto.Channel, error) {
+		position, err := channelPosition(enabled, index)
+		if err != nil {
+			return nil, err
+		}
+		if enabled[position].GetRole() == gomeshproto.Channel_PRIMARY {
+			return nil, errors.New("cannot delete PRIMARY channel")
+		}
+
+		return append(enabled[:position:position], enabled[position+1:]...), nil
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
 }
 
 func setChannel(c *cli.Context) error {
@@ -222,10 +336,264 @@ func setUrl(c *cli.Context) error {
 	radio := getRadio(c)
 	defer radio.Close()
 
-	err := radio.SetChannelURL(c.String("url"))
+	channelSet, err := decodeChannelURL(c.String("url"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	nodeNum, err := getNodeNum(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	err = applyChannelSet(radio, nodeNum, channelSet)
 	if err != nil {
 		return cli.Exit(err, 0)
 	}
 
 	return nil
 }
+
+// applyChannelSet writes every channel in the set to the radio, with the first channel as the primary.
+// Unused channel slots are disabled and the LoRa config is set if the set includes one
+func applyChannelSet(r gomesh.Radio, nodeNum uint32, channelSet *gomeshproto.ChannelSet) error {
+
+	if len(channelSet.Settings) > maxChannels {
+		return fmt.Errorf("channel URL has %d channels, the radio supports %d", len(channelSet.Settings), maxChannels)
+	}
+
+	enabled := make([]*gomeshproto.Channel, 0, len(channelSet.Settings))
+	for _, settings := range channelSet.Settings {
+		enabled = append(enabled, &gomeshproto.Channel{Settings: settings})
+	}
+
+	adminPackets := make([]*gomeshproto.AdminMessage, 0, maxChannels+1)
+	for _, channel := range layoutChannels(enabled) {
+		adminPackets = append(adminPackets, &gomeshproto.AdminMessage{
+			PayloadVariant: &gomeshproto.AdminMessage_SetChannel{
+				SetChannel: channel,
+			},
+		})
+	}
+
+	if channelSet.LoraConfig != nil {
+		adminPackets = append(adminPackets, &gomeshproto.AdminMessage{
+			PayloadVariant: &gomeshproto.AdminMessage_SetConfig{
+				SetConfig: &gomeshproto.Config{
+					PayloadVariant: &gomeshproto.Config_Lora{
+						Lora: channelSet.LoraConfig,
+					},
+				},
+			},
+		})
+	}
+
+	return editSettings(r, nodeNum, adminPackets)
+}
+
+func moveChannel(c *cli.Context) error {
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	err := rearrangeChannels(radio, func(enabled []*gomeshproto.Channel) ([]*gomeshproto.Channel, error) {
+		from, err := channelPosition(enabled, c.Int("from"))
+		if err != nil {
+			return nil, err
+		}
+		to, err := channelPosition(enabled, c.Int("to"))
+		if err != nil {
+			return nil, err
+		}
+		return moveChannelSlot(enabled, from, to)
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+func promoteChannel(c *cli.Context) error {
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	index, err := strconv.Atoi(c.Args().First())
+	if err != nil {
+		return cli.Exit("A channel index is required", 0)
+	}
+
+	err = rearrangeChannels(radio, func(enabled []*gomeshproto.Channel) ([]*gomeshproto.Channel, error) {
+		from, err := channelPosition(enabled, index)
+		if err != nil {
+			return nil, err
+		}
+		return moveChannelSlot(enabled, from, 0)
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+// rearrangeChannels reads the enabled channels from the radio, lets rearrange reorder them and then
+// writes every slot that changed in a single edit settings transaction
+func rearrangeChannels(r gomesh.Radio, rearrange func(enabled []*gomeshproto.Channel) ([]*gomeshproto.Channel, error)) error {
+
+	channels, err := r.GetChannels()
+	if err != nil {
+		return err
+	}
+
+	enabled := enabledChannels(channels)
+	rearranged, err := rearrange(enabled)
+	if err != nil {
+		return err
+	}
+
+	current := make(map[int32]*gomeshproto.Channel)
+	for _, channel := range channels {
+		current[channel.Index] = channel
+	}
+
+	adminPackets := make([]*gomeshproto.AdminMessage, 0, maxChannels)
+	for _, channel := range layoutChannels(rearranged) {
+		if existing, ok := current[channel.Index]; ok && channelsEqual(existing, channel) {
+			continue
+		}
+		adminPackets = append(adminPackets, &gomeshproto.AdminMessage{
+			PayloadVariant: &gomeshproto.AdminMessage_SetChannel{
+				SetChannel: channel,
+			},
+		})
+	}
+
+	if len(adminPackets) == 0 {
+		return nil
+	}
+
+	nodeNum, err := getNodeNum(r)
+	if err != nil {
+		return err
+	}
+
+	return editSettings(r, nodeNum, adminPackets)
+}
+
+// enabledChannels returns the channels that aren't disabled, ordered by index
+func enabledChannels(channels []*gomeshproto.Channel) []*gomeshproto.Channel {
+	enabled := make([]*gomeshproto.Channel, 0, len(channels))
+	for _, channel := range channels {
+		if channel.GetRole() != gomeshproto.Channel_DISABLED {
+			enabled = append(enabled, channel)
+		}
+	}
+
+	sort.SliceStable(enabled, func(i, j int) bool {
+		// The primary channel is always first even if the radio reports it at a different index
+		if enabled[i].GetRole() == gomeshproto.Channel_PRIMARY {
+			return enabled[j].GetRole() != gomeshproto.Channel_PRIMARY
+		}
+		if enabled[j].GetRole() == gomeshproto.Channel_PRIMARY {
+			return false
+		}
+		return enabled[i].Index < enabled[j].Index
+	})
+
+	return enabled
+}
+
+// channelPosition returns the position of the channel with the given radio index in the enabled channels
+func channelPosition(enabled []*gomeshproto.Channel, index int) (int, error) {
+	for i, channel := range enabled {
+		if int(channel.Index) == index {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no channel at index %d", index)
+}
+
+// moveChannelSlot moves the enabled channel at position from to position to, shifting the channels
+// between them. Positions are counted in the enabled channels, not radio indexes
+func moveChannelSlot(enabled []*gomeshproto.Channel, from int, to int) ([]*gomeshproto.Channel, error) {
+	if from < 0 || from >= len(enabled) {
+		return nil, fmt.Errorf("no channel at position %d", from)
+	}
+	if to < 0 || to >= len(enabled) {
+		return nil, fmt.Errorf("position %d is outside the enabled channels 0-%d", to, len(enabled)-1)
+	}
+
+	moved := enabled[from]
+	rearranged := make([]*gomeshproto.Channel, 0, len(enabled))
+	rearranged = append(rearranged, enabled[:from]...)
+	rearranged = append(rearranged, enabled[from+1:]...)
+	rearranged = append(rearranged[:to], append([]*gomeshproto.Channel{moved}, rearranged[to:]...)...)
+
+	return rearranged, nil
+}
+
+// layoutChannels assigns contiguous indexes to the enabled channels with the first as the primary,
+// and returns every channel slot on the radio with the unused slots disabled
+func layoutChannels(enabled []*gomeshproto.Channel) []*gomeshproto.Channel {
+	slots := make([]*gomeshproto.Channel, 0, maxChannels)
+	for i := 0; i < maxChannels; i++ {
+		channel := &gomeshproto.Channel{
+			Index: int32(i),
+			Role:  gomeshproto.Channel_DISABLED,
+		}
+		if i < len(enabled) {
+			channel.Settings = enabled[i].Settings
+			if i == 0 {
+				channel.Role = gomeshproto.Channel_PRIMARY
+			} else {
+				channel.Role = gomeshproto.Channel_SECONDARY
+			}
+		}
+		slots = append(slots, channel)
+	}
+
+	return slots
+}
+
+// channelsEqual checks if two channels would be stored the same way on the radio
+func channelsEqual(a *gomeshproto.Channel, b *gomeshproto.Channel) bool {
+	if a.GetRole() != b.GetRole() {
+		return false
+	}
+	if a.GetRole() == gomeshproto.Channel_DISABLED {
+		return true
+	}
+
+	return proto.Equal(a.GetSettings(), b.GetSettings())
+}
+
+// editSettings sends the admin messages to the radio inside a single edit settings transaction so
+// they're committed together
+func editSettings(r gomesh.Radio, nodeNum uint32, adminPackets []*gomeshproto.AdminMessage) error {
+
+	begin := gomeshproto.AdminMessage{
+		PayloadVariant: &gomeshproto.AdminMessage_BeginEditSettings{
+			BeginEditSettings: true,
+		},
+	}
+	if err := sendAdminMessage(r, nodeNum, &begin); err != nil {
+		return err
+	}
+
+	for _, adminPacket := range adminPackets {
+		if err := sendAdminMessage(r, nodeNum, adminPacket); err != nil {
+			return err
+		}
+	}
+
+	commit := gomeshproto.AdminMessage{
+		PayloadVariant: &gomeshproto.AdminMessage_CommitEditSettings{
+			CommitEditSettings: true,
+		},
+	}
+
+	return sendAdminMessage(r, nodeNum, &commit)
+}
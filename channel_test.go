@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"google.golang.org/protobuf/proto"
+)
+
+func testChannelSet() *gomeshproto.ChannelSet {
+	return &gomeshproto.ChannelSet{
+		Settings: []*gomeshproto.ChannelSettings{
+			{Name: "", Psk: []byte{1}},
+			{Name: "hiking", Psk: []byte("0123456789abcdef0123456789abcdef")},
+		},
+		LoraConfig: &gomeshproto.Config_LoRaConfig{
+			UsePreset:   true,
+			ModemPreset: gomeshproto.Config_LoRaConfig_LONG_SLOW,
+			Region:      gomeshproto.Config_LoRaConfig_EU_868,
+			HopLimit:    5,
+			TxEnabled:   true,
+		},
+	}
+}
+
+func TestChannelURLRoundTrip(t *testing.T) {
+	channelSet := testChannelSet()
+
+	url, err := encodeChannelURL(channelSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(url, channelURLPrefix) {
+		t.Fatalf("URL %s doesn't start with %s", url, channelURLPrefix)
+	}
+
+	decoded, err := decodeChannelURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(decoded, channelSet) {
+		t.Errorf("decoded %v, want %v", decoded, channelSet)
+	}
+}
+
+func TestDecodeOldChannelURL(t *testing.T) {
+	channelSet := testChannelSet()
+	out, err := proto.Marshal(channelSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Older clients made /c/ URLs with standard, padded base64
+	url := "https://meshtastic.org/c/#" + base64.StdEncoding.EncodeToString(out)
+	decoded, err := decodeChannelURL(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proto.Equal(decoded, channelSet) {
+		t.Errorf("decoded %v, want %v", decoded, channelSet)
+	}
+}
+
+func TestDecodeOldPrimaryChannelURL(t *testing.T) {
+	// The primary channel URL used to hold just the channel's settings
+	for _, settings := range []*gomeshproto.ChannelSettings{
+		{Psk: []byte{1}},
+		{Name: "hiking", Psk: []byte("0123456789abcdef0123456789abcdef"), UplinkEnabled: true},
+		{ChannelNum: 3, Name: "hiking", Id: 42},
+	} {
+		out, err := proto.Marshal(settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decoded, err := decodeChannelURL("https://www.meshtastic.org/c/#" + base64.RawURLEncoding.EncodeToString(out))
+		if err != nil {
+			t.Errorf("%v: %v", settings, err)
+			continue
+		}
+		want := &gomeshproto.ChannelSet{Settings: []*gomeshproto.ChannelSettings{settings}}
+		if !proto.Equal(decoded, want) {
+			t.Errorf("decoded %v, want %v", decoded, want)
+		}
+	}
+}
+
+func TestChannelURLsRoundTrip(t *testing.T) {
+	channelSet := testChannelSet()
+	primary, secondary := channelSet.Settings[0], channelSet.Settings[1]
+
+	// The primary channel comes first in the URLs wherever it is on the radio, and disabled channels
+	// are left out
+	channels := []*gomeshproto.Channel{
+		{Index: 0, Role: gomeshproto.Channel_SECONDARY, Settings: secondary},
+		{Index: 1, Role: gomeshproto.Channel_DISABLED, Settings: &gomeshproto.ChannelSettings{Name: "old"}},
+		{Index: 2, Role: gomeshproto.Channel_PRIMARY, Settings: primary},
+	}
+
+	primaryURL, fullURL, err := channelURLs(channels, channelSet.LoraConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeChannelURL(fullURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &gomeshproto.ChannelSet{Settings: []*gomeshproto.ChannelSettings{primary, secondary}, LoraConfig: channelSet.LoraConfig}
+	if !proto.Equal(decoded, want) {
+		t.Errorf("full URL decoded as %v, want %v", decoded, want)
+	}
+
+	decoded, err = decodeChannelURL(primaryURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = &gomeshproto.ChannelSet{Settings: []*gomeshproto.ChannelSettings{primary}, LoraConfig: channelSet.LoraConfig}
+	if !proto.Equal(decoded, want) {
+		t.Errorf("primary URL decoded as %v, want %v", decoded, want)
+	}
+}
+
+func TestDecodeChannelURLErrors(t *testing.T) {
+	empty, err := encodeChannelURL(&gomeshproto.ChannelSet{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, url := range []string{empty, channelURLPrefix + "not*base64", channelURLPrefix + "_w"} {
+		if _, err := decodeChannelURL(url); err == nil {
+			t.Errorf("%s decoded without an error", url)
+		}
+	}
+}
+
+// testChannels returns enabled channels with the given radio indexes, named after their index, with the
+// first as the primary channel
+func testChannels(indexes ...int32) []*gomeshproto.Channel {
+	channels := make([]*gomeshproto.Channel, 0, len(indexes))
+	for i, index := range indexes {
+		role := gomeshproto.Channel_SECONDARY
+		if i == 0 {
+			role = gomeshproto.Channel_PRIMARY
+		}
+		channels = append(channels, &gomeshproto.Channel{
+			Index:    index,
+			Role:     role,
+			Settings: &gomeshproto.ChannelSettings{Name: fmt.Sprint("ch", index)},
+		})
+	}
+
+	return channels
+}
+
+// channelNames returns the names of channels in order
+func channelNames(channels []*gomeshproto.Channel) string {
+	names := make([]string, 0, len(channels))
+	for _, channel := range channels {
+		names = append(names, channel.GetSettings().GetName())
+	}
+
+	return strings.Join(names, " ")
+}
+
+func TestMoveChannelSlot(t *testing.T) {
+	tests := []struct {
+		from int
+		to   int
+		want string
+	}{
+		{3, 1, "ch0 ch3 ch1 ch2"},
+		{1, 3, "ch0 ch2 ch3 ch1"},
+		{2, 0, "ch2 ch0 ch1 ch3"},
+		{0, 3, "ch1 ch2 ch3 ch0"},
+		{2, 2, "ch0 ch1 ch2 ch3"},
+	}
+
+	for _, test := range tests {
+		enabled := testChannels(0, 1, 2, 3)
+		moved, err := moveChannelSlot(enabled, test.from, test.to)
+		if err != nil {
+			t.Errorf("moving %d to %d: %v", test.from, test.to, err)
+			continue
+		}
+		if got := channelNames(moved); got != test.want {
+			t.Errorf("moving %d to %d gave %s, want %s", test.from, test.to, got, test.want)
+		}
+		if got := channelNames(enabled); got != "ch0 ch1 ch2 ch3" {
+			t.Errorf("moving %d to %d changed the channels passed in to %s", test.from, test.to, got)
+		}
+	}
+
+	for _, positions := range [][2]int{{-1, 0}, {4, 0}, {0, -1}, {0, 4}} {
+		if _, err := moveChannelSlot(testChannels(0, 1, 2, 3), positions[0], positions[1]); err == nil {
+			t.Errorf("moving %d to %d gave no error", positions[0], positions[1])
+		}
+	}
+}
+
+func TestMoveChannelByIndex(t *testing.T) {
+	// Radio indexes with gaps are moved by the channels at those indexes, then renumbered from 0
+	enabled := enabledChannels(append(testChannels(0, 2, 5), &gomeshproto.Channel{Index: 3, Role: gomeshproto.Channel_DISABLED}))
+	from, err := channelPosition(enabled, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := channelPosition(enabled, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	moved, err := moveChannelSlot(enabled, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := channelNames(moved); got != "ch0 ch5 ch2" {
+		t.Errorf("moving index 5 to index 2 gave %s", got)
+	}
+
+	if _, err := channelPosition(enabled, 3); err == nil {
+		t.Errorf("found a position for a disabled channel")
+	}
+}
+
+func TestLayoutChannels(t *testing.T) {
+	enabled, err := moveChannelSlot(testChannels(0, 1, 2), 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	slots := layoutChannels(enabled)
+	if len(slots) != maxChannels {
+		t.Fatalf("got %d slots, want %d", len(slots), maxChannels)
+	}
+
+	wantNames := []string{"ch2", "ch0", "ch1"}
+	for i, slot := range slots {
+		if slot.Index != int32(i) {
+			t.Errorf("slot %d has index %d", i, slot.Index)
+		}
+
+		switch {
+		case i == 0:
+			if slot.Role != gomeshproto.Channel_PRIMARY || slot.GetSettings().GetName() != wantNames[i] {
+				t.Errorf("slot 0 is %s %s, want PRIMARY %s", slot.Role, slot.GetSettings().GetName(), wantNames[i])
+			}
+		case i < len(wantNames):
+			if slot.Role != gomeshproto.Channel_SECONDARY || slot.GetSettings().GetName() != wantNames[i] {
+				t.Errorf("slot %d is %s %s, want SECONDARY %s", i, slot.Role, slot.GetSettings().GetName(), wantNames[i])
+			}
+		default:
+			if slot.Role != gomeshproto.Channel_DISABLED || slot.Settings != nil {
+				t.Errorf("slot %d is %s %v, want an empty DISABLED slot", i, slot.Role, slot.Settings)
+			}
+		}
+	}
+
+	// A slot that only changed its role still has to be written
+	if channelsEqual(slots[1], testChannels(0)[0]) {
+		t.Errorf("a PRIMARY and a SECONDARY channel with the same settings compare equal")
+	}
+	if !channelsEqual(slots[7], &gomeshproto.Channel{Index: 7, Role: gomeshproto.Channel_DISABLED, Settings: &gomeshproto.ChannelSettings{Name: "old"}}) {
+		t.Errorf("disabled slots with different settings don't compare equal")
+	}
+}
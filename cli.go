@@ -2,6 +2,7 @@ package main
 
 import (
 	"os"
+	"time"
 
 	"github.com/urfave/cli/v2"
 )
@@ -51,6 +52,21 @@ func Init() {
 						Aliases: []string{"n"},
 						Usage:   "Show all nodes on the mesh",
 						Action:  showNodeInfo,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "export",
+								Usage: "Export the nodes with a position as geojson, kml or gpx",
+							},
+							&cli.StringFlag{
+								Name:    "out",
+								Aliases: []string{"o"},
+								Usage:   "File to write the export to. Defaults to stdout",
+							},
+							&cli.DurationFlag{
+								Name:  "track",
+								Usage: "Record position updates from the mesh for this long and export them as tracks",
+							},
+						},
 					},
 					{
 						Name:    "position",
@@ -63,6 +79,20 @@ func Init() {
 						Aliases: []string{"m"},
 						Usage:   "Display node metrics",
 						Action:  showMetricInfo,
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "listen",
+								Usage: "Also listen for telemetry broadcast by nodes for this long",
+							},
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "Output metrics in JSON",
+							},
+							&cli.BoolFlag{
+								Name:  "csv",
+								Usage: "Output metrics as CSV",
+							},
+						},
 					},
 				},
 			},
@@ -83,7 +113,7 @@ func Init() {
 							&cli.StringFlag{
 								Name:     "message",
 								Aliases:  []string{"m"},
-								Usage:    "Message to send, must be under 237 characters",
+								Usage:    "Message to send. The limit is on UTF-8 bytes rather than characters, see --overflow",
 								Required: true,
 							},
 							&cli.Int64Flag{
@@ -98,6 +128,42 @@ func Init() {
 								Usage:   "Channel to send the message on",
 								Value:   0,
 							},
+							&cli.StringFlag{
+								Name:  "overflow",
+								Usage: "What to do with a message over the byte limit for one packet: split it into parts, truncate it or error",
+								Value: "split",
+							},
+							&cli.StringFlag{
+								Name:  "pki",
+								Usage: "Encrypt direct messages with the destination's public key: prefer it when the key is known, require it, or off to leave it to the radio",
+								Value: "prefer",
+							},
+							&cli.StringFlag{
+								Name:    "reply-to",
+								Aliases: []string{"r"},
+								Usage:   "Packet ID of the message to reply to, as shown by recv",
+							},
+						},
+					},
+					{
+						Name:        "react",
+						Usage:       "React to a message with an emoji",
+						UsageText:   "react <packetid> <emoji>",
+						Description: "Sends an emoji reaction to a message, using the packet ID shown by recv. Send it to the same node and channel as the message",
+						Action:      sendReaction,
+						Flags: []cli.Flag{
+							&cli.Int64Flag{
+								Name:    "to",
+								Aliases: []string{"t"},
+								Usage:   "Address to send to. Leave blank for broadcast",
+								Value:   0,
+							},
+							&cli.Int64Flag{
+								Name:    "channel",
+								Aliases: []string{"c"},
+								Usage:   "Channel to send the reaction on",
+								Value:   0,
+							},
 						},
 					},
 					{
@@ -109,7 +175,7 @@ func Init() {
 							&cli.Int64Flag{
 								Name:    "channel",
 								Aliases: []string{"c"},
-								Usage:   "Channel to listen for messages on",
+								Usage:   "Only show messages received on this channel",
 								Value:   0,
 							},
 							&cli.BoolFlag{
@@ -117,6 +183,15 @@ func Init() {
 								Usage:    "Output messages in JSON with a newline between each message",
 								Required: false,
 							},
+							&cli.StringFlag{
+								Name:    "filter",
+								Aliases: []string{"f"},
+								Usage:   "Only show messages matching a filter expression, such as 'from=!a1b2c3d4 && snr>-10 && text~\"alert\"'",
+							},
+							&cli.BoolFlag{
+								Name:  "mark-seen",
+								Usage: "Remember the messages shown so message fetch-history skips them",
+							},
 							&cli.BoolFlag{
 								Name:     "exit",
 								Aliases:  []string{"e"},
@@ -126,6 +201,40 @@ func Init() {
 							},
 						},
 					},
+					{
+						Name:        "fetch-history",
+						Usage:       "Fetch missed messages from a store and forward server",
+						Description: "Ask a node running the store and forward module to replay the messages it saved, skipping messages already received. Server heartbeats and statistics are reported while waiting",
+						Action:      fetchHistory,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "server",
+								Aliases:  []string{"s"},
+								Usage:    "Node running the store and forward server",
+								Required: true,
+							},
+							&cli.DurationFlag{
+								Name:    "window",
+								Aliases: []string{"w"},
+								Usage:   "How far back to fetch messages from",
+								Value:   2 * time.Hour,
+							},
+							&cli.DurationFlag{
+								Name:  "timeout",
+								Usage: "Stop once nothing has been received from the server for this long",
+								Value: 2 * time.Minute,
+							},
+							&cli.Int64Flag{
+								Name:    "channel",
+								Aliases: []string{"c"},
+								Usage:   "Channel the server is on",
+							},
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "Output messages in JSON",
+							},
+						},
+					},
 				},
 			},
 			{
@@ -178,7 +287,7 @@ func Init() {
 						Name:        "delete",
 						Usage:       "Deletes a channel",
 						UsageText:   "delete - Delete a channel to the radio",
-						Description: "Delete a channel from the radio. Cannot delete a PRIMARY channel",
+						Description: "Delete a channel from the radio. Cannot delete a PRIMARY channel. The channels after it are moved down so the channel indexes stay contiguous",
 						Action:      deleteChannel,
 						Flags: []cli.Flag{
 							&cli.StringFlag{
@@ -217,12 +326,62 @@ func Init() {
 							},
 						},
 					},
+					{
+						Name:        "move",
+						Usage:       "Move a channel to a different index",
+						Description: "Move a channel to the index of another channel, shifting the channels in between. Both indexes are the ones shown by channel info. Moving a channel to index 0 makes it the PRIMARY channel, and channels are renumbered from 0 afterwards so there are no gaps",
+						Action:      moveChannel,
+						Flags: []cli.Flag{
+							&cli.IntFlag{
+								Name:     "from",
+								Aliases:  []string{"f"},
+								Usage:    "Index of the channel to move, as shown by channel info",
+								Required: true,
+							},
+							&cli.IntFlag{
+								Name:     "to",
+								Aliases:  []string{"t"},
+								Usage:    "Index of the channel whose place it takes, as shown by channel info",
+								Required: true,
+							},
+						},
+					},
+					{
+						Name:        "promote",
+						Usage:       "Make a channel the primary channel",
+						UsageText:   "promote <index> - Make the channel at index the primary channel",
+						Description: "Move a channel to index 0 so it becomes the PRIMARY channel. The previous primary channel becomes a secondary channel at index 1",
+						ArgsUsage:   "<index>",
+						Action:      promoteChannel,
+					},
 					{
 						Name:        "options",
 						Usage:       "Show channel options",
 						Description: "Show all avaible channel options that can be set",
 						Action:      showChannelOptions,
 					},
+					{
+						Name:        "qr",
+						Usage:       "Show a QR code for the channel URL",
+						Description: "Render the full channel URL, or the primary channel URL, as a QR code in the terminal or as a PNG image",
+						Action:      showChannelQR,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:     "primary",
+								Usage:    "Use the primary channel URL instead of the full channel URL",
+								Required: false,
+							},
+							&cli.StringFlag{
+								Name:  "png",
+								Usage: "Write the QR code to a PNG file instead of the terminal",
+							},
+							&cli.IntFlag{
+								Name:  "size",
+								Usage: "Width and height of the PNG image in pixels",
+								Value: 512,
+							},
+						},
+					},
 				},
 			},
 			{
@@ -273,34 +432,746 @@ func Init() {
 				Name:        "location",
 				Usage:       "Set location",
 				UsageText:   "location [command] - Set location",
-				Description: "Manually set GPS coordinates using int values for lat, long, and alt",
+				Description: "Manually set GPS coordinates using decimal degrees, degrees minutes seconds, MGRS or UTM",
 				ArgsUsage:   "",
 				Subcommands: []*cli.Command{
 					{
 						Name:        "set",
 						Usage:       "Set a location",
-						Description: "Manually set GPS coordinates using int values for lat, long, and alt",
+						Description: "Manually set GPS coordinates. --lat and --long accept decimal degrees (31.0481775), degrees minutes seconds (31°2'53.4\"N) or degrees * 1e7 (310481775). --grid accepts an MGRS (17SLA1234567890) or UTM (17S 512345 3434567) grid reference instead",
 						Action:      setLocation,
 						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "lat",
+								Usage: "Latitude",
+							},
+							&cli.StringFlag{
+								Name:    "long",
+								Aliases: []string{"lon"},
+								Usage:   "Longitude",
+							},
+							&cli.StringFlag{
+								Name:    "grid",
+								Aliases: []string{"g"},
+								Usage:   "MGRS or UTM grid reference to use instead of --lat and --long",
+							},
+							&cli.IntFlag{
+								Name:  "alt",
+								Usage: "Altitude in meters",
+								Value: 0,
+							},
+							&cli.BoolFlag{
+								Name:  "fixed",
+								Usage: "Set the location as the radio's fixed position",
+							},
+							&cli.BoolFlag{
+								Name:  "remove-fixed",
+								Usage: "Remove the radio's fixed position",
+							},
+							&cli.BoolFlag{
+								Name:    "broadcast",
+								Aliases: []string{"b"},
+								Usage:   "Broadcast the position to the mesh immediately",
+							},
+							&cli.UintFlag{
+								Name:  "precision",
+								Usage: "Number of bits of precision to broadcast the position with, between 1 and 32",
+								Value: 32,
+							},
 							&cli.Int64Flag{
-								Name:     "lat",
-								Usage:    "Latitude",
-								Required: true,
+								Name:    "channel",
+								Aliases: []string{"c"},
+								Usage:   "Channel to broadcast the position on",
+								Value:   0,
+							},
+						},
+					},
+					{
+						Name:        "follow",
+						Usage:       "Set the location from a GPS on this computer",
+						Description: "Read positions from gpsd or an NMEA serial port or file and periodically set them on the radio. A position is sent once --interval has passed and the radio has moved at least --distance meters, or once --max-interval has passed",
+						Action:      followLocation,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "gpsd",
+								Usage: "Address of a gpsd server, for example localhost:2947",
+							},
+							&cli.StringFlag{
+								Name:  "nmea",
+								Usage: "Serial port or file to read NMEA sentences from",
+							},
+							&cli.IntFlag{
+								Name:  "baud",
+								Usage: "Baud rate of the NMEA serial port",
+								Value: 9600,
+							},
+							&cli.DurationFlag{
+								Name:    "interval",
+								Aliases: []string{"i"},
+								Usage:   "Minimum time between positions sent to the radio",
+								Value:   30 * time.Second,
+							},
+							&cli.Float64Flag{
+								Name:    "distance",
+								Aliases: []string{"d"},
+								Usage:   "Minimum distance in meters the radio has to move before a new position is sent",
+								Value:   100,
+							},
+							&cli.DurationFlag{
+								Name:  "max-interval",
+								Usage: "Send a position after this long even if the radio hasn't moved",
+								Value: 15 * time.Minute,
+							},
+							&cli.BoolFlag{
+								Name:    "broadcast",
+								Aliases: []string{"b"},
+								Usage:   "Broadcast each position to the mesh as it's sent",
+							},
+							&cli.UintFlag{
+								Name:  "precision",
+								Usage: "Number of bits of precision to broadcast positions with, between 1 and 32",
+								Value: 32,
 							},
 							&cli.Int64Flag{
-								Name:     "long",
-								Usage:    "Longitude",
+								Name:    "channel",
+								Aliases: []string{"c"},
+								Usage:   "Channel to broadcast positions on",
+								Value:   0,
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:        "metrics",
+				Usage:       "Request and record telemetry from nodes",
+				UsageText:   "metrics [command]",
+				Description: "Request telemetry from nodes on the mesh and log it over time",
+				ArgsUsage:   "",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "request",
+						Usage:       "Request telemetry from a node",
+						Description: "Ask a node for its current telemetry and wait for the reply, instead of waiting for the node to broadcast it",
+						Action:      requestMetrics,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "to",
+								Aliases:  []string{"t"},
+								Usage:    "Node ID or number to request telemetry from",
 								Required: true,
 							},
+							&cli.StringFlag{
+								Name:  "type",
+								Usage: "Telemetry to request: device, environment, power or air-quality",
+								Value: "device",
+							},
+							&cli.Int64Flag{
+								Name:    "channel",
+								Aliases: []string{"c"},
+								Usage:   "Channel to send the request on",
+								Value:   0,
+							},
+							&cli.DurationFlag{
+								Name:  "timeout",
+								Usage: "How long to wait for a reply",
+								Value: time.Minute,
+							},
+							&cli.BoolFlag{
+								Name:  "json",
+								Usage: "Output the reply in JSON",
+							},
+						},
+					},
+					{
+						Name:        "log",
+						Usage:       "Log telemetry and positions to CSV or InfluxDB",
+						Description: "Listen for telemetry and position packets and write each reading to a CSV file or InfluxDB, tagged with the node and type of reading",
+						Action:      logMetrics,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "out",
+								Aliases: []string{"o"},
+								Usage:   "CSV file to append readings to",
+							},
+							&cli.Int64Flag{
+								Name:  "max-size",
+								Usage: "Rotate the CSV file once it reaches this many megabytes. 0 to disable",
+								Value: 0,
+							},
+							&cli.BoolFlag{
+								Name:  "daily",
+								Usage: "Rotate the CSV file every day",
+							},
+							&cli.StringFlag{
+								Name:  "influx",
+								Usage: "URL of an InfluxDB server to write readings to, e.g. http://localhost:8086",
+							},
+							&cli.StringFlag{
+								Name:  "influx-db",
+								Usage: "InfluxDB 1 database to write to",
+								Value: "meshtastic",
+							},
+							&cli.StringFlag{
+								Name:  "influx-org",
+								Usage: "InfluxDB 2 organization to write to",
+							},
+							&cli.StringFlag{
+								Name:  "influx-bucket",
+								Usage: "InfluxDB 2 bucket to write to. Uses the InfluxDB 2 API when set",
+							},
+							&cli.StringFlag{
+								Name:    "influx-token",
+								Usage:   "InfluxDB API token",
+								EnvVars: []string{"INFLUX_TOKEN"},
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:        "serve",
+				Usage:       "Serve a web dashboard for the mesh",
+				UsageText:   "serve [--http :8080] [--grpc 127.0.0.1:50051] - Serve a web dashboard for the mesh",
+				Description: "Keep a connection to the radio open and serve a map of the nodes with their links, live messages and telemetry charts, along with a JSON API. The radio can also be served over gRPC with the service in meshrpc/radio.proto",
+				ArgsUsage:   "",
+				Action:      serve,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "http",
+						Usage: "Address to serve the dashboard and API on, e.g. :8080",
+					},
+					&cli.StringFlag{
+						Name:  "grpc",
+						Usage: "Address to serve the gRPC service on, e.g. 127.0.0.1:50051",
+					},
+					&cli.BoolFlag{
+						Name:  "grpc-admin",
+						Usage: "Allow gRPC clients to change channels and config and to factory reset the radio",
+					},
+					&cli.StringFlag{
+						Name:  "tiles",
+						Usage: "URL template for map tiles",
+						Value: "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+					},
+					&cli.StringFlag{
+						Name:  "tiles-dir",
+						Usage: "Serve map tiles from a local {z}/{x}/{y}.png directory for offline use",
+					},
+				},
+			},
+			{
+				Name:        "keys",
+				Usage:       "Manage the radio's key pair, admin keys and the keys of other nodes",
+				UsageText:   "keys [command]",
+				Description: "Show or change the Curve25519 key pair used for direct messages, and the admin keys allowed to change the radio's settings. Needs firmware 2.5 or newer",
+				ArgsUsage:   "",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "show",
+						Usage:  "Show the radio's public key and admin keys",
+						Action: showKeys,
+						Flags: []cli.Flag{
+							&cli.BoolFlag{
+								Name:  "private",
+								Usage: "Also show the private key",
+							},
+						},
+					},
+					{
+						Name:        "generate",
+						Usage:       "Generate a new key pair",
+						Description: "Clears the private key so the radio generates a new key pair when it restarts. Other nodes will warn that the key changed",
+						Action:      generateKeys,
+					},
+					{
+						Name:      "import",
+						Usage:     "Set the private key, such as to move a node's identity to a new radio",
+						UsageText: "import <base64 private key>",
+						Action:    importPrivateKey,
+					},
+					{
+						Name:  "admin",
+						Usage: "Manage the public keys allowed to administer the radio remotely",
+						Subcommands: []*cli.Command{
+							{
+								Name:      "add",
+								Usage:     "Add an admin key",
+								UsageText: "add <base64 public key>",
+								Action:    addAdminKey,
+							},
+							{
+								Name:      "remove",
+								Usage:     "Remove an admin key",
+								UsageText: "remove <base64 public key>",
+								Action:    removeAdminKey,
+							},
+						},
+					},
+					{
+						Name:        "trust",
+						Usage:       "Accept a node's new public key",
+						UsageText:   "trust <node>",
+						Description: "Remembers the public key a node has now in place of the one first seen for it. Check the new key with the node's owner first",
+						Action:      trustKey,
+					},
+				},
+			},
+			{
+				Name:        "listen",
+				Usage:       "Show packets received from the mesh, and act on them",
+				UsageText:   "listen --filter <expression> --exec <command> --rules <file>",
+				Description: "Shows every decoded packet the radio receives, on any port, until cancelled. Use --filter to only show some of them, and --exec or --rules to run commands, send automatic replies or call webhooks for matching packets",
+				ArgsUsage:   "",
+				Action:      listenMesh,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "filter",
+						Aliases: []string{"f"},
+						Usage:   "Only show packets matching a filter expression, such as 'port=POSITION_APP && hops<=1'",
+					},
+					&cli.Int64Flag{
+						Name:    "channel",
+						Aliases: []string{"c"},
+						Usage:   "Only show packets received on this channel",
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "Output packets in JSON with a newline between each packet",
+					},
+					&cli.BoolFlag{
+						Name:    "quiet",
+						Aliases: []string{"q"},
+						Usage:   "Don't show packets, only run --exec and --rules",
+					},
+					&cli.StringFlag{
+						Name:  "exec",
+						Usage: "Command to run for each packet matching --filter, with the packet as JSON on stdin and its fields in MESH_ environment variables",
+					},
+					&cli.DurationFlag{
+						Name:  "exec-timeout",
+						Usage: "Stop commands that run for longer than this",
+						Value: 30 * time.Second,
+					},
+					&cli.StringFlag{
+						Name:  "rules",
+						Usage: "JSON file of rules mapping filters to commands to run, automatic replies and webhooks",
+					},
+					&cli.DurationFlag{
+						Name:  "reply-interval",
+						Usage: "Minimum time between automatic replies, so the radio isn't flooded",
+						Value: 30 * time.Second,
+					},
+				},
+			},
+			{
+				Name:        "pipe",
+				Usage:       "Send stdin over the mesh and write received data to stdout",
+				UsageText:   "pipe --port-num PRIVATE_APP --to <node> - Pipe data between two nodes",
+				Description: "Read stdin and send it over the mesh on a port number, splitting each read into as many packets as it needs, and write data received on the same port number to stdout once all of its packets have arrived. Use --raw to send and receive packets without the header used to reassemble them, such as to talk to the serial module",
+				ArgsUsage:   "",
+				Action:      runPipe,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "port-num",
+						Usage: "Port number to send and receive on, such as SERIAL_APP, PRIVATE_APP or a number",
+						Value: "PRIVATE_APP",
+					},
+					&cli.StringFlag{
+						Name:  "to",
+						Usage: "Node to send to and receive from, defaults to all nodes",
+					},
+					&cli.Int64Flag{
+						Name:    "channel",
+						Aliases: []string{"c"},
+						Usage:   "Channel to send on",
+					},
+					&cli.BoolFlag{
+						Name:  "ack",
+						Usage: "Wait for each packet to be acknowledged before sending the next one",
+					},
+					&cli.DurationFlag{
+						Name:  "ack-timeout",
+						Usage: "How long to wait for an acknowledgement before sending a packet again",
+						Value: 30 * time.Second,
+					},
+					&cli.IntFlag{
+						Name:  "retries",
+						Usage: "Number of times to send a packet again before giving up",
+						Value: 3,
+					},
+					&cli.DurationFlag{
+						Name:  "interval",
+						Usage: "Minimum time between packets, so the radio's queue isn't filled",
+						Value: 2 * time.Second,
+					},
+					&cli.BoolFlag{
+						Name:  "raw",
+						Usage: "Send and receive packet payloads as they are, without reassembling them",
+					},
+					&cli.BoolFlag{
+						Name:    "listen",
+						Aliases: []string{"l"},
+						Usage:   "Keep receiving after stdin is closed",
+					},
+				},
+			},
+			{
+				Name:        "file",
+				Usage:       "Transfer files between nodes",
+				UsageText:   "file [command]",
+				Description: "Send a file to another node running file recv. Missing chunks are sent again until the receiver has the whole file and has checked its checksum, and interrupted transfers resume where they left off",
+				ArgsUsage:   "",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "send",
+						Usage:       "Send a file",
+						UsageText:   "file send <path> --to <node>",
+						Description: "Send a file to a node running file recv, waiting while the radio's transmit airtime is over --max-airtime",
+						Action:      sendFile,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "to",
+								Usage: "Node to send the file to",
+							},
+							&cli.Int64Flag{
+								Name:    "channel",
+								Aliases: []string{"c"},
+								Usage:   "Channel to send on",
+							},
+							&cli.StringFlag{
+								Name:  "port-num",
+								Usage: "Port number to transfer on, which must match at both ends",
+								Value: "PRIVATE_APP",
+							},
+							&cli.DurationFlag{
+								Name:  "interval",
+								Usage: "Minimum time between chunks",
+								Value: 3 * time.Second,
+							},
+							&cli.Float64Flag{
+								Name:  "max-airtime",
+								Usage: "Pause while the radio's transmit airtime over the last hour is above this percentage",
+								Value: 8,
+							},
+							&cli.DurationFlag{
+								Name:  "timeout",
+								Usage: "How long to wait for the receiver to reply",
+								Value: time.Minute,
+							},
 							&cli.IntFlag{
-								Name:     "alt",
-								Usage:    "Altitude",
+								Name:  "retries",
+								Usage: "Number of times to ask the receiver again before giving up",
+								Value: 5,
+							},
+						},
+					},
+					{
+						Name:        "recv",
+						Usage:       "Receive files",
+						Description: "Receive files sent with file send into a directory. Partly received files are kept in the directory so the transfer can be resumed",
+						Action:      receiveFiles,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "dir",
+								Usage: "Directory to save files to",
+								Value: ".",
+							},
+							&cli.StringFlag{
+								Name:  "from",
+								Usage: "Only accept files from this node",
+							},
+							&cli.StringFlag{
+								Name:  "port-num",
+								Usage: "Port number to transfer on, which must match at both ends",
+								Value: "PRIVATE_APP",
+							},
+							&cli.Int64Flag{
+								Name:  "max-size",
+								Usage: "Largest file in bytes to accept",
+								Value: 1 << 20,
+							},
+							&cli.BoolFlag{
+								Name:  "once",
+								Usage: "Exit after receiving a file",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:        "waypoint",
+				Usage:       "Manage waypoints",
+				UsageText:   "waypoint [command]",
+				Description: "Send and delete waypoints, and keep a list of waypoints received from the mesh",
+				ArgsUsage:   "",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "add",
+						Usage:       "Send a waypoint to the mesh",
+						Description: "Send a waypoint to the mesh. --lat and --long accept the same formats as location set, or --grid accepts an MGRS or UTM grid reference. Sending a waypoint again with the same --id updates it",
+						Action:      addWaypoint,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:     "name",
+								Aliases:  []string{"n"},
+								Usage:    "Name of the waypoint",
 								Required: true,
 							},
+							&cli.StringFlag{
+								Name:  "description",
+								Usage: "Description of the waypoint",
+							},
+							&cli.StringFlag{
+								Name:  "lat",
+								Usage: "Latitude",
+							},
+							&cli.StringFlag{
+								Name:    "long",
+								Aliases: []string{"lon"},
+								Usage:   "Longitude",
+							},
+							&cli.StringFlag{
+								Name:    "grid",
+								Aliases: []string{"g"},
+								Usage:   "MGRS or UTM grid reference to use instead of --lat and --long",
+							},
+							&cli.StringFlag{
+								Name:  "icon",
+								Usage: "Single emoji to show for the waypoint",
+							},
+							&cli.DurationFlag{
+								Name:  "expire",
+								Usage: "Remove the waypoint after this long, defaults to never",
+							},
+							&cli.BoolFlag{
+								Name:  "locked",
+								Usage: "Only allow this radio to change the waypoint",
+							},
+							&cli.UintFlag{
+								Name:  "id",
+								Usage: "ID of a waypoint to update, defaults to a new waypoint",
+							},
+							&cli.StringFlag{
+								Name:  "to",
+								Usage: "Node to send to, defaults to all nodes",
+							},
+							&cli.Int64Flag{
+								Name:  "channel",
+								Usage: "Channel to send on",
+							},
+							&cli.StringFlag{
+								Name:  "store",
+								Usage: "Waypoint store to use instead of the one in the user config directory",
+							},
+						},
+					},
+					{
+						Name:        "delete",
+						Usage:       "Delete a waypoint",
+						UsageText:   "waypoint delete <id>",
+						Description: "Remove a waypoint from the mesh by sending it again with an expiry in the past",
+						Action:      deleteWaypoint,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "to",
+								Usage: "Node to send to, defaults to all nodes",
+							},
+							&cli.Int64Flag{
+								Name:  "channel",
+								Usage: "Channel to send on",
+							},
+							&cli.StringFlag{
+								Name:  "store",
+								Usage: "Waypoint store to use instead of the one in the user config directory",
+							},
+						},
+					},
+					{
+						Name:        "list",
+						Usage:       "List waypoints",
+						Description: "List the waypoints that have been sent or received and haven't expired. With --listen, waypoints received from the mesh are added to the list first",
+						Action:      listWaypoints,
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:    "listen",
+								Aliases: []string{"l"},
+								Usage:   "Listen for waypoints from the mesh for this long",
+							},
+							&cli.StringFlag{
+								Name:  "store",
+								Usage: "Waypoint store to use instead of the one in the user config directory",
+							},
+						},
+					},
+					{
+						Name:        "import",
+						Usage:       "Send the waypoints in a GPX file",
+						UsageText:   "waypoint import <file.gpx>",
+						Description: "Send each waypoint in a GPX file to the mesh, using the GPX symbol as the icon",
+						Action:      importWaypoints,
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:  "expire",
+								Usage: "Remove the waypoints after this long",
+							},
+							&cli.StringFlag{
+								Name:  "to",
+								Usage: "Node to send to, defaults to all nodes",
+							},
+							&cli.Int64Flag{
+								Name:  "channel",
+								Usage: "Channel to send on",
+							},
+							&cli.StringFlag{
+								Name:  "store",
+								Usage: "Waypoint store to use instead of the one in the user config directory",
+							},
+						},
+					},
+					{
+						Name:        "export",
+						Usage:       "Export waypoints to GPX",
+						Description: "Write the waypoints in the list to a GPX file",
+						Action:      exportWaypoints,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "out",
+								Aliases: []string{"o"},
+								Usage:   "File to write to instead of stdout",
+							},
+							&cli.StringFlag{
+								Name:  "store",
+								Usage: "Waypoint store to use instead of the one in the user config directory",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:        "rangetest",
+				Usage:       "Run a range test",
+				UsageText:   "rangetest [command]",
+				Description: "Send numbered packets from one radio and log which ones arrive at another, along with the signal and distance",
+				ArgsUsage:   "",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "send",
+						Usage:       "Send range test packets",
+						Description: "Send a numbered packet on the range test port at a fixed interval until cancelled",
+						Action:      sendRangeTest,
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:    "interval",
+								Aliases: []string{"i"},
+								Usage:   "Time between packets",
+								Value:   30 * time.Second,
+							},
+							&cli.IntFlag{
+								Name:  "count",
+								Usage: "Number of packets to send, or 0 to send until cancelled",
+							},
+							&cli.StringFlag{
+								Name:  "to",
+								Usage: "Node to send to, defaults to all nodes",
+							},
+							&cli.Int64Flag{
+								Name:  "channel",
+								Usage: "Channel to send on",
+							},
+						},
+					},
+					{
+						Name:        "recv",
+						Usage:       "Receive range test packets",
+						Description: "Log range test packets with their SNR, RSSI, hops and the sender's position and distance, and print the packet loss for each sender when cancelled",
+						Action:      receiveRangeTest,
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:  "csv",
+								Usage: "File to log received packets to",
+							},
+							&cli.DurationFlag{
+								Name:    "duration",
+								Aliases: []string{"d"},
+								Usage:   "Stop after this long instead of when cancelled",
+							},
+							&cli.StringFlag{
+								Name:  "lat",
+								Usage: "Latitude to measure distance from, if the radio has no position",
+							},
+							&cli.StringFlag{
+								Name:    "long",
+								Aliases: []string{"lon"},
+								Usage:   "Longitude to measure distance from, if the radio has no position",
+							},
+							&cli.StringFlag{
+								Name:    "grid",
+								Aliases: []string{"g"},
+								Usage:   "MGRS or UTM grid reference to use instead of --lat and --long",
+							},
 						},
 					},
 				},
 			},
+			{
+				Name:        "mesh",
+				Usage:       "Inspect the mesh",
+				UsageText:   "mesh [command]",
+				Description: "Show how the nodes on the mesh are connected",
+				ArgsUsage:   "",
+				Subcommands: []*cli.Command{
+					{
+						Name:        "topology",
+						Usage:       "Show the links between nodes",
+						Description: "Collect neighbor info reports and traceroute results into a graph with the SNR of each link, and show weak links and nodes the mesh depends on",
+						Action:      showTopology,
+						Flags: []cli.Flag{
+							&cli.DurationFlag{
+								Name:    "listen",
+								Aliases: []string{"l"},
+								Usage:   "How long to listen for neighbor info reports and traceroute replies",
+								Value:   5 * time.Minute,
+							},
+							&cli.StringSliceFlag{
+								Name:    "traceroute",
+								Aliases: []string{"t"},
+								Usage:   "Send a traceroute to this node before listening, can be given more than once",
+							},
+							&cli.Int64Flag{
+								Name:  "channel",
+								Usage: "Channel to send traceroutes on",
+							},
+							&cli.StringFlag{
+								Name:    "format",
+								Aliases: []string{"f"},
+								Usage:   "Output format, either table, dot or graphml",
+								Value:   "table",
+							},
+							&cli.StringFlag{
+								Name:    "out",
+								Aliases: []string{"o"},
+								Usage:   "File to write the graph to instead of stdout",
+							},
+						},
+					},
+				},
+			},
+			{
+				Name:        "exporter",
+				Usage:       "Export mesh metrics to Prometheus",
+				UsageText:   "exporter --listen :9464 - Export mesh metrics to Prometheus",
+				Description: "Keep the radio open and serve device, environment and power metrics, SNR, RSSI and hops for each node along with packet counts by port number on /metrics",
+				ArgsUsage:   "",
+				Action:      runExporter,
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "listen",
+						Usage: "Address to serve metrics on",
+						Value: ":9464",
+					},
+				},
+			},
 			{
 				Name:        "reset",
 				Usage:       "Factory reset the radio",
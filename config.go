@@ -1,10 +1,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 
 	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
 	"github.com/urfave/cli/v2"
 )
 
@@ -102,6 +104,23 @@ func printConfig(r gomesh.Radio) error {
 	return nil
 }
 
+// getLoraConfig returns the LoRa config currently set on the radio
+func getLoraConfig(r gomesh.Radio) (*gomeshproto.Config_LoRaConfig, error) {
+
+	configSettings, _, err := r.GetRadioConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, config := range configSettings {
+		if loraConfig := config.Config.GetLora(); loraConfig != nil {
+			return loraConfig, nil
+		}
+	}
+
+	return nil, errors.New("no LoRa config found")
+}
+
 func showRadioConfig(c *cli.Context) error {
 	radio := getRadio(c)
 	defer radio.Close()
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// WGS84 ellipsoid and UTM projection constants
+const (
+	wgs84A      = 6378137.0
+	wgs84F      = 1 / 298.257223563
+	utmK0       = 0.9996
+	utmFalseE   = 500000.0
+	utmFalseN   = 10000000.0
+	mgrsBands   = "CDEFGHJKLMNPQRSTUVWX"
+	mgrsRowSet  = "ABCDEFGHJKLMNPQRSTUV"
+	mgrsColSets = "ABCDEFGH" + "JKLMNPQR" + "STUVWXYZ"
+)
+
+// dmsPattern matches a coordinate in degrees, minutes and seconds with an optional hemisphere before or
+// after it. Units can be symbols or the letters d, m and s in either case, so the s for seconds is only
+// part of the number when it directly follows it
+var dmsPattern = regexp.MustCompile(`(?i)^([NSEW])?\s*([-+]?\d+(?:\.\d+)?)(?:[°d:\s]+(\d+(?:\.\d+)?))?(?:['′m:\s]+(\d+(?:\.\d+)?))?(["″]|s)?\s*([NSEW])?$`)
+var mgrsPattern = regexp.MustCompile(`^(\d{1,2})([C-HJ-NP-X])([A-HJ-NP-Z])([A-HJ-NP-V])(\d*)$`)
+var utmPattern = regexp.MustCompile(`^(\d{1,2})([C-HJ-NP-X])\s+(\d+(?:\.\d+)?)\s*E?\s+(\d+(?:\.\d+)?)\s*N?$`)
+
+// parseCoordinate parses a latitude or longitude written in decimal degrees ("31.0481775", "-81.58"),
+// degrees minutes seconds ("31°2'53.4\"N", "81 34 54.4 W", "31d2m53s") or degrees decimal minutes
+// ("31 2.89 N"). Whole numbers of seven or more digits are treated as degrees multiplied by 1e7 for
+// compatibility with the integer values the radio uses
+func parseCoordinate(value string, isLat bool) (float64, error) {
+	limit := 180.0
+	positive, negative := "E", "W"
+	kind := "longitude"
+	if isLat {
+		limit = 90.0
+		positive, negative = "N", "S"
+		kind = "latitude"
+	}
+
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, errors.New("no coordinate provided")
+	}
+
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil && math.Abs(float64(i)) > limit {
+		degrees := float64(i) / 1e7
+		if len(strings.TrimLeft(value, "+-")) < 7 || math.Abs(degrees) > limit {
+			return 0, fmt.Errorf("%s is out of range", value)
+		}
+		return degrees, nil
+	}
+
+	matches := dmsPattern.FindStringSubmatch(value)
+	if matches == nil || (matches[1] != "" && matches[6] != "") {
+		return 0, fmt.Errorf("unable to parse coordinate %q", value)
+	}
+
+	// Without a seconds value an s straight after the number can only be the hemisphere
+	hemisphere := strings.ToUpper(matches[1] + matches[6])
+	if strings.EqualFold(matches[5], "s") && matches[4] == "" {
+		if hemisphere != "" {
+			return 0, fmt.Errorf("unable to parse coordinate %q", value)
+		}
+		hemisphere = "S"
+	}
+
+	sign := 1.0
+	switch hemisphere {
+	case "", positive:
+	case negative:
+		sign = -1.0
+	default:
+		return 0, fmt.Errorf("%s isn't a hemisphere for a %s", hemisphere, kind)
+	}
+
+	degrees, _ := strconv.ParseFloat(matches[2], 64)
+	if strings.HasPrefix(matches[2], "-") {
+		sign = -sign
+		degrees = -degrees
+	}
+
+	for i, divisor := range []float64{60, 3600} {
+		if matches[i+3] == "" {
+			continue
+		}
+		part, _ := strconv.ParseFloat(matches[i+3], 64)
+		if part >= 60 {
+			return 0, fmt.Errorf("unable to parse coordinate %q", value)
+		}
+		degrees += part / divisor
+	}
+
+	degrees *= sign
+	if math.Abs(degrees) > limit {
+		return 0, fmt.Errorf("%v is out of range", degrees)
+	}
+
+	return degrees, nil
+}
+
+// parseGridReference parses an MGRS ("17SLA1234567890", "17S LA 12345 67890") or UTM
+// ("17S 512345 3434567") grid reference. The letter after the UTM zone is the latitude band, so
+// locations in the southern hemisphere use the bands C to M
+func parseGridReference(value string) (lat float64, lon float64, err error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+
+	if matches := utmPattern.FindStringSubmatch(value); matches != nil {
+		zone, _ := strconv.Atoi(matches[1])
+		easting, _ := strconv.ParseFloat(matches[3], 64)
+		northing, _ := strconv.ParseFloat(matches[4], 64)
+		if zone < 1 || zone > 60 {
+			return 0, 0, fmt.Errorf("invalid UTM zone %d", zone)
+		}
+		lat, lon = utmToLatLon(zone, matches[2][0] >= 'N', easting, northing)
+		return lat, lon, nil
+	}
+
+	matches := mgrsPattern.FindStringSubmatch(strings.Join(strings.Fields(value), ""))
+	if matches == nil {
+		return 0, 0, fmt.Errorf("unable to parse grid reference %q", value)
+	}
+
+	zone, _ := strconv.Atoi(matches[1])
+	if zone < 1 || zone > 60 {
+		return 0, 0, fmt.Errorf("invalid UTM zone %d", zone)
+	}
+	band := matches[2][0]
+	digits := matches[5]
+	if len(digits)%2 != 0 || len(digits) > 10 {
+		return 0, 0, fmt.Errorf("grid reference %q must have the same number of easting and northing digits", value)
+	}
+
+	// The 100km square column letters cycle through three sets depending on the zone
+	set := (zone - 1) % 3
+	column := strings.IndexByte(mgrsColSets[set*8:set*8+8], matches[3][0])
+	if column < 0 {
+		return 0, 0, fmt.Errorf("invalid 100km square %s for zone %d", matches[3], zone)
+	}
+
+	// The row letters repeat every 2000km and are offset by five letters in even zones
+	row := strings.IndexByte(mgrsRowSet, matches[4][0])
+	if zone%2 == 0 {
+		row = (row - 5 + len(mgrsRowSet)) % len(mgrsRowSet)
+	}
+
+	precision := len(digits) / 2
+	scale := math.Pow(10, float64(5-precision))
+	easting, northing := 0.0, 0.0
+	if precision > 0 {
+		e, _ := strconv.Atoi(digits[:precision])
+		n, _ := strconv.Atoi(digits[precision:])
+		// Use the center of the square described by the reference
+		easting = (float64(e) + 0.5) * scale
+		northing = (float64(n) + 0.5) * scale
+	} else {
+		easting, northing = 50000, 50000
+	}
+
+	easting += float64(column+1) * 100000
+
+	// Pick the 2000km cycle that puts the square inside the latitude band
+	north := band >= 'N'
+	bandLat := -80.0 + 8*float64(strings.IndexByte(mgrsBands, band))
+	_, minNorthing := latLonToUTM(bandLat, float64(zone*6-183))
+	origin := float64(row) * 100000
+	for origin < math.Floor(minNorthing/100000)*100000 {
+		origin += 2000000
+	}
+	northing += origin
+
+	lat, lon = utmToLatLon(zone, north, easting, northing)
+	return lat, lon, nil
+}
+
+// latLonToUTM projects a position to a UTM easting and northing using the zone's central meridian
+// of the given longitude
+func latLonToUTM(lat float64, lon float64) (easting float64, northing float64) {
+	zone := int(math.Floor((lon+180)/6)) + 1
+	if zone > 60 {
+		zone = 60
+	}
+	centralMeridian := float64(zone*6 - 183)
+
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	phi := lat * math.Pi / 180
+	n := wgs84A / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+	t := math.Tan(phi) * math.Tan(phi)
+	c := ep2 * math.Cos(phi) * math.Cos(phi)
+	a := math.Cos(phi) * (lon - centralMeridian) * math.Pi / 180
+	m := meridianArc(phi)
+
+	easting = utmK0*n*(a+(1-t+c)*math.Pow(a, 3)/6+(5-18*t+t*t+72*c-58*ep2)*math.Pow(a, 5)/120) + utmFalseE
+	northing = utmK0 * (m + n*math.Tan(phi)*(a*a/2+(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+(61-58*t+t*t+600*c-330*ep2)*math.Pow(a, 6)/720))
+	if lat < 0 {
+		northing += utmFalseN
+	}
+
+	return easting, northing
+}
+
+// utmToLatLon converts a UTM easting and northing to decimal degrees
+func utmToLatLon(zone int, north bool, easting float64, northing float64) (lat float64, lon float64) {
+	e2 := wgs84F * (2 - wgs84F)
+	ep2 := e2 / (1 - e2)
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	x := easting - utmFalseE
+	y := northing
+	if !north {
+		y -= utmFalseN
+	}
+
+	m := y / utmK0
+	mu := m / (wgs84A * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	n1 := wgs84A / math.Sqrt(1-e2*math.Sin(phi1)*math.Sin(phi1))
+	t1 := math.Tan(phi1) * math.Tan(phi1)
+	c1 := ep2 * math.Cos(phi1) * math.Cos(phi1)
+	r1 := wgs84A * (1 - e2) / math.Pow(1-e2*math.Sin(phi1)*math.Sin(phi1), 1.5)
+	d := x / (n1 * utmK0)
+
+	lat = phi1 - (n1*math.Tan(phi1)/r1)*(d*d/2-(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+	lon = (d - (1+2*t1+c1)*math.Pow(d, 3)/6 + (5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / math.Cos(phi1)
+
+	return lat * 180 / math.Pi, float64(zone*6-183) + lon*180/math.Pi
+}
+
+// meridianArc returns the distance along the meridian from the equator to the latitude phi in radians
+func meridianArc(phi float64) float64 {
+	e2 := wgs84F * (2 - wgs84F)
+	return wgs84A * ((1-e2/4-3*e2*e2/64-5*e2*e2*e2/256)*phi -
+		(3*e2/8+3*e2*e2/32+45*e2*e2*e2/1024)*math.Sin(2*phi) +
+		(15*e2*e2/256+45*e2*e2*e2/1024)*math.Sin(4*phi) -
+		(35*e2*e2*e2/3072)*math.Sin(6*phi))
+}
+
+// distanceMeters returns the great circle distance between two positions in meters
+func distanceMeters(lat1 float64, lon1 float64, lat2 float64, lon2 float64) float64 {
+	const earthRadius = 6371008.8
+
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// degreesToInt converts decimal degrees to the degrees * 1e7 integers used by the radio
+func degreesToInt(degrees float64) int32 {
+	return int32(math.Round(degrees * 1e7))
+}
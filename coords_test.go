@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCoordinate(t *testing.T) {
+	tests := []struct {
+		value string
+		isLat bool
+		want  float64
+	}{
+		{"31.0481775", true, 31.0481775},
+		{"-81.58", false, -81.58},
+		{"31°2'53.4\"N", true, 31.0481667},
+		{"31°2'53.4\"S", true, -31.0481667},
+		{"81 34 54.4 W", false, -81.5817778},
+		{"31 2.89 N", true, 31.0481667},
+		{"31 2.89S", true, -31.0481667},
+		{"S31 2.89", true, -31.0481667},
+		{"31d2m53s", true, 31.0480556},
+		{"31D2M53S", true, 31.0480556},
+		{"31d2m53s S", true, -31.0480556},
+		{"310481775", true, 31.0481775},
+		{"-815817755", false, -81.5817755},
+	}
+
+	for _, test := range tests {
+		got, err := parseCoordinate(test.value, test.isLat)
+		if err != nil {
+			t.Errorf("%s: %v", test.value, err)
+			continue
+		}
+		if math.Abs(got-test.want) > 1e-7 {
+			t.Errorf("%s parsed as %.7f, want %.7f", test.value, got, test.want)
+		}
+	}
+}
+
+func TestParseCoordinateErrors(t *testing.T) {
+	tests := []struct {
+		value string
+		isLat bool
+	}{
+		{"", true},
+		{"120", true},
+		{"-200", false},
+		{"910000000", true},
+		{"31d2m53sE", true},
+		{"81 34 54.4 N", false},
+		{"N31S", true},
+		{"31 61", true},
+		{"north", true},
+	}
+
+	for _, test := range tests {
+		if got, err := parseCoordinate(test.value, test.isLat); err == nil {
+			t.Errorf("%s parsed as %v without an error", test.value, got)
+		}
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// mapNode is a node with a known position and the properties included when it's exported
+type mapNode struct {
+	Num        uint32
+	Name       string
+	Hardware   string
+	Battery    uint32
+	HasBattery bool
+	Snr        float32
+	LastHeard  time.Time
+	Lat        float64
+	Lon        float64
+	Alt        int32
+	Track      []trackPoint
+}
+
+// trackPoint is a position reported by a node at a point in time
+type trackPoint struct {
+	Lat  float64
+	Lon  float64
+	Alt  int32
+	Time time.Time
+}
+
+func exportNodes(c *cli.Context, r gomesh.Radio) error {
+
+	var write func(w io.Writer, nodes []*mapNode) error
+	switch c.String("export") {
+	case "geojson":
+		write = writeGeoJSON
+	case "kml":
+		write = writeKML
+	case "gpx":
+		write = writeGPX
+	default:
+		return cli.Exit("Export format must be geojson, kml or gpx", 0)
+	}
+
+	responses, err := r.GetRadioInfo()
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	nodes := make(map[uint32]*mapNode)
+	for _, response := range responses {
+		if nodeInfo := response.GetNodeInfo(); nodeInfo != nil {
+			nodes[nodeInfo.Num] = newMapNode(nodeInfo)
+		}
+	}
+
+	if c.Duration("track") > 0 {
+		fmt.Fprintf(os.Stderr, "Recording positions for %s\n", c.Duration("track"))
+		err = listenPackets(r, time.Now().Add(c.Duration("track")), func(packet *gomeshproto.MeshPacket) error {
+			if packet.GetDecoded().GetPortnum() != gomeshproto.PortNum_POSITION_APP {
+				return nil
+			}
+			position := gomeshproto.Position{}
+			if err := unmarshalPayload(packet, &position); err != nil {
+				return nil
+			}
+			node, ok := nodes[packet.From]
+			if !ok {
+				node = &mapNode{Num: packet.From, Name: nodeID(packet.From)}
+				nodes[packet.From] = node
+			}
+			node.addPosition(&position, time.Now())
+			return nil
+		})
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	positioned := make([]*mapNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Lat != 0 || node.Lon != 0 {
+			positioned = append(positioned, node)
+		}
+	}
+	sort.Slice(positioned, func(i, j int) bool { return positioned[i].Num < positioned[j].Num })
+
+	out := io.Writer(os.Stdout)
+	if c.String("out") != "" {
+		f, err := os.Create(c.String("out"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := write(out, positioned); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+// newMapNode creates a map node from the node database entry of the radio
+func newMapNode(info *gomeshproto.NodeInfo) *mapNode {
+	node := &mapNode{
+		Num:  info.Num,
+		Name: nodeID(info.Num),
+		Snr:  info.Snr,
+	}
+
+	if info.User != nil {
+		node.Name = info.User.LongName
+		node.Hardware = info.User.HwModel.String()
+	}
+	if info.DeviceMetrics != nil {
+		node.Battery = info.DeviceMetrics.BatteryLevel
+		node.HasBattery = true
+	}
+	if info.LastHeard > 0 {
+		node.LastHeard = time.Unix(int64(info.LastHeard), 0).UTC()
+	}
+	if info.Position != nil {
+		node.addPosition(info.Position, node.LastHeard)
+	}
+
+	return node
+}
+
+// addPosition moves the node to the position and records it in the node's track
+func (n *mapNode) addPosition(position *gomeshproto.Position, heard time.Time) {
+	if position.LatitudeI == 0 && position.LongitudeI == 0 {
+		return
+	}
+
+	n.Lat = float64(position.LatitudeI) / 1e7
+	n.Lon = float64(position.LongitudeI) / 1e7
+	n.Alt = position.Altitude
+
+	if position.Time > 0 {
+		heard = time.Unix(int64(position.Time), 0).UTC()
+	}
+	if heard.After(n.LastHeard) {
+		n.LastHeard = heard
+	}
+
+	n.Track = append(n.Track, trackPoint{Lat: n.Lat, Lon: n.Lon, Alt: n.Alt, Time: heard})
+}
+
+// properties returns the exported properties of the node
+func (n *mapNode) properties() map[string]interface{} {
+	properties := map[string]interface{}{
+		"id":       nodeID(n.Num),
+		"num":      n.Num,
+		"name":     n.Name,
+		"hardware": n.Hardware,
+		"snr":      n.Snr,
+	}
+	if n.HasBattery {
+		properties["battery"] = n.Battery
+	}
+	if !n.LastHeard.IsZero() {
+		properties["last_heard"] = n.LastHeard.Format(time.RFC3339)
+	}
+
+	return properties
+}
+
+// propertyNames are the exported properties in the order they're written to KML and GPX
+var propertyNames = []string{"id", "num", "name", "hardware", "battery", "snr", "last_heard"}
+
+func writeGeoJSON(w io.Writer, nodes []*mapNode) error {
+	type geometry struct {
+		Type        string      `json:"type"`
+		Coordinates interface{} `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string                 `json:"type"`
+		Geometry   geometry               `json:"geometry"`
+		Properties map[string]interface{} `json:"properties"`
+	}
+
+	features := make([]feature, 0, len(nodes))
+	for _, node := range nodes {
+		features = append(features, feature{
+			Type:       "Feature",
+			Geometry:   geometry{Type: "Point", Coordinates: []float64{node.Lon, node.Lat, float64(node.Alt)}},
+			Properties: node.properties(),
+		})
+
+		if len(node.Track) > 1 {
+			coordinates := make([][]float64, 0, len(node.Track))
+			times := make([]string, 0, len(node.Track))
+			for _, point := range node.Track {
+				coordinates = append(coordinates, []float64{point.Lon, point.Lat, float64(point.Alt)})
+				times = append(times, point.Time.Format(time.RFC3339))
+			}
+			properties := node.properties()
+			properties["coordTimes"] = times
+			features = append(features, feature{
+				Type:       "Feature",
+				Geometry:   geometry{Type: "LineString", Coordinates: coordinates},
+				Properties: properties,
+			})
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{"FeatureCollection", features})
+}
+
+func writeKML(w io.Writer, nodes []*mapNode) error {
+	type data struct {
+		Name  string `xml:"name,attr"`
+		Value string `xml:"value"`
+	}
+	type coordinates struct {
+		Coordinates string `xml:"coordinates"`
+	}
+	type placemark struct {
+		Name         string       `xml:"name"`
+		ExtendedData []data       `xml:"ExtendedData>Data"`
+		Point        *coordinates `xml:"Point,omitempty"`
+		LineString   *coordinates `xml:"LineString,omitempty"`
+	}
+
+	placemarks := make([]placemark, 0, len(nodes))
+	for _, node := range nodes {
+		properties := node.properties()
+		extendedData := make([]data, 0, len(propertyNames))
+		for _, name := range propertyNames {
+			if value, ok := properties[name]; ok {
+				extendedData = append(extendedData, data{Name: name, Value: fmt.Sprint(value)})
+			}
+		}
+
+		placemarks = append(placemarks, placemark{
+			Name:         node.Name,
+			ExtendedData: extendedData,
+			Point:        &coordinates{fmt.Sprintf("%.7f,%.7f,%d", node.Lon, node.Lat, node.Alt)},
+		})
+
+		if len(node.Track) > 1 {
+			track := ""
+			for _, point := range node.Track {
+				track += fmt.Sprintf("%.7f,%.7f,%d ", point.Lon, point.Lat, point.Alt)
+			}
+			placemarks = append(placemarks, placemark{
+				Name:         node.Name + " track",
+				ExtendedData: extendedData,
+				LineString:   &coordinates{track},
+			})
+		}
+	}
+
+	document := struct {
+		XMLName    xml.Name    `xml:"kml"`
+		Namespace  string      `xml:"xmlns,attr"`
+		Name       string      `xml:"Document>name"`
+		Placemarks []placemark `xml:"Document>Placemark"`
+	}{
+		Namespace:  "http://www.opengis.net/kml/2.2",
+		Name:       "Meshtastic Nodes",
+		Placemarks: placemarks,
+	}
+
+	return writeXML(w, document)
+}
+
+func writeGPX(w io.Writer, nodes []*mapNode) error {
+	type point struct {
+		Lat  float64 `xml:"lat,attr"`
+		Lon  float64 `xml:"lon,attr"`
+		Ele  int32   `xml:"ele"`
+		Time string  `xml:"time,omitempty"`
+		Name string  `xml:"name,omitempty"`
+		Desc string  `xml:"desc,omitempty"`
+	}
+	type track struct {
+		Name   string  `xml:"name"`
+		Points []point `xml:"trkseg>trkpt"`
+	}
+
+	waypoints := make([]point, 0, len(nodes))
+	tracks := make([]track, 0)
+	for _, node := range nodes {
+		properties := node.properties()
+		desc := ""
+		for _, name := range propertyNames {
+			if value, ok := properties[name]; ok {
+				desc += fmt.Sprintf("%s: %v\n", name, value)
+			}
+		}
+
+		waypoint := point{Lat: node.Lat, Lon: node.Lon, Ele: node.Alt, Name: node.Name, Desc: desc}
+		if !node.LastHeard.IsZero() {
+			waypoint.Time = node.LastHeard.Format(time.RFC3339)
+		}
+		waypoints = append(waypoints, waypoint)
+
+		if len(node.Track) > 1 {
+			points := make([]point, 0, len(node.Track))
+			for _, trackPoint := range node.Track {
+				points = append(points, point{
+					Lat:  trackPoint.Lat,
+					Lon:  trackPoint.Lon,
+					Ele:  trackPoint.Alt,
+					Time: trackPoint.Time.Format(time.RFC3339),
+				})
+			}
+			tracks = append(tracks, track{Name: node.Name, Points: points})
+		}
+	}
+
+	document := struct {
+		XMLName   xml.Name `xml:"gpx"`
+		Namespace string   `xml:"xmlns,attr"`
+		Version   string   `xml:"version,attr"`
+		Creator   string   `xml:"creator,attr"`
+		Waypoints []point  `xml:"wpt"`
+		Tracks    []track  `xml:"trk"`
+	}{
+		Namespace: "http://www.topografix.com/GPX/1/1",
+		Version:   "1.1",
+		Creator:   "meshtastic-go",
+		Waypoints: waypoints,
+		Tracks:    tracks,
+	}
+
+	return writeXML(w, document)
+}
+
+// writeXML writes an indented XML document with the XML header
+func writeXML(w io.Writer, document interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// nodeGaugeHelp describes the gauges kept for each node besides its telemetry readings
+var nodeGaugeHelp = map[string]string{
+	"snr_db":                       "SNR of the last packet received from the node",
+	"rssi_dbm":                     "RSSI of the last packet received from the node",
+	"hops_away":                    "Number of hops the last packet from the node took",
+	"last_heard_timestamp_seconds": "Time the node was last heard",
+}
+
+// meshCollector keeps the latest values for each node and counts packets for the Prometheus exporter
+type meshCollector struct {
+	mu      sync.Mutex
+	nodes   map[uint32]*collectedNode
+	packets map[string]uint64
+}
+
+// collectedNode is the latest value of each gauge for a node
+type collectedNode struct {
+	name   string
+	gauges map[string]float64
+}
+
+func runExporter(c *cli.Context) error {
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	collector := &meshCollector{
+		nodes:   make(map[uint32]*collectedNode),
+		packets: make(map[string]uint64),
+	}
+
+	responses, err := radio.GetRadioInfo()
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	for _, response := range responses {
+		collector.add(response)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", collector)
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- http.ListenAndServe(c.String("listen"), mux)
+	}()
+	go func() {
+		for {
+			responses, err := radio.ReadResponse(false)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for _, response := range responses {
+				collector.add(response)
+			}
+		}
+	}()
+
+	fmt.Printf("Serving metrics on %s/metrics\n", c.String("listen"))
+
+	return cli.Exit(<-errs, 0)
+}
+
+// node returns the collected values for a node, adding it if it hasn't been seen before. It must be
+// called with the lock held
+func (m *meshCollector) node(num uint32) *collectedNode {
+	node, ok := m.nodes[num]
+	if !ok {
+		node = &collectedNode{name: nodeID(num), gauges: make(map[string]float64)}
+		m.nodes[num] = node
+	}
+
+	return node
+}
+
+// add updates the collector from a packet received from the radio
+func (m *meshCollector) add(response *gomeshproto.FromRadio) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if info := response.GetNodeInfo(); info != nil {
+		node := m.node(info.Num)
+		if info.User.GetLongName() != "" {
+			node.name = info.User.LongName
+		}
+		if info.Snr != 0 {
+			node.gauges["snr_db"] = widenFloat(info.Snr)
+		}
+		if info.LastHeard > 0 {
+			node.gauges["last_heard_timestamp_seconds"] = float64(info.LastHeard)
+			node.gauges["hops_away"] = float64(info.HopsAway)
+		}
+		if info.DeviceMetrics != nil {
+			telemetry := &gomeshproto.Telemetry{
+				Variant: &gomeshproto.Telemetry_DeviceMetrics{DeviceMetrics: info.DeviceMetrics},
+			}
+			for _, reading := range telemetryReadings(telemetry) {
+				node.gauges[reading.Name] = reading.Value
+			}
+		}
+		return
+	}
+
+	packet := response.GetPacket()
+	if packet == nil {
+		return
+	}
+
+	if packet.GetDecoded() == nil {
+		m.packets["ENCRYPTED"]++
+		return
+	}
+	m.packets[packet.GetDecoded().GetPortnum().String()]++
+
+	node := m.node(packet.From)
+	node.gauges["last_heard_timestamp_seconds"] = float64(time.Now().Unix())
+	if packet.RxTime > 0 {
+		node.gauges["last_heard_timestamp_seconds"] = float64(packet.RxTime)
+	}
+	if packet.RxSnr != 0 {
+		node.gauges["snr_db"] = widenFloat(packet.RxSnr)
+	}
+	if packet.RxRssi != 0 {
+		node.gauges["rssi_dbm"] = float64(packet.RxRssi)
+	}
+	if packet.HopStart > 0 {
+		node.gauges["hops_away"] = float64(packet.HopStart - packet.HopLimit)
+	}
+
+	switch packet.GetDecoded().GetPortnum() {
+	case gomeshproto.PortNum_NODEINFO_APP:
+		user := gomeshproto.User{}
+		if err := unmarshalPayload(packet, &user); err == nil && user.LongName != "" {
+			node.name = user.LongName
+		}
+	case gomeshproto.PortNum_TELEMETRY_APP:
+		telemetry := gomeshproto.Telemetry{}
+		if err := unmarshalPayload(packet, &telemetry); err == nil {
+			for _, reading := range telemetryReadings(&telemetry) {
+				node.gauges[reading.Name] = reading.Value
+			}
+		}
+	}
+}
+
+// ServeHTTP writes the collected metrics in the Prometheus text format
+func (m *meshCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.write(w)
+}
+
+// write writes the collected metrics in the Prometheus text format. It must be called with the lock held
+func (m *meshCollector) write(w io.Writer) {
+	nums := make([]uint32, 0, len(m.nodes))
+	names := make(map[string]bool)
+	for num, node := range m.nodes {
+		nums = append(nums, num)
+		for name := range node.gauges {
+			names[name] = true
+		}
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	gauges := make([]string, 0, len(names))
+	for name := range names {
+		gauges = append(gauges, name)
+	}
+	sort.Strings(gauges)
+
+	fmt.Fprintf(w, "# HELP meshtastic_nodes Number of nodes seen on the mesh\n")
+	fmt.Fprintf(w, "# TYPE meshtastic_nodes gauge\n")
+	fmt.Fprintf(w, "meshtastic_nodes %d\n", len(m.nodes))
+
+	for _, name := range gauges {
+		help := nodeGaugeHelp[name]
+		if help == "" {
+			help = telemetryHelp[name]
+		}
+		fmt.Fprintf(w, "# HELP meshtastic_node_%s %s\n", name, help)
+		fmt.Fprintf(w, "# TYPE meshtastic_node_%s gauge\n", name)
+		for _, num := range nums {
+			node := m.nodes[num]
+			if value, ok := node.gauges[name]; ok {
+				fmt.Fprintf(w, "meshtastic_node_%s{node=\"%s\",name=\"%s\"} %g\n", name, nodeID(num), escapeLabel(node.name), value)
+			}
+		}
+	}
+
+	portnums := make([]string, 0, len(m.packets))
+	for portnum := range m.packets {
+		portnums = append(portnums, portnum)
+	}
+	sort.Strings(portnums)
+
+	fmt.Fprintf(w, "# HELP meshtastic_packets_received_total Packets received from the mesh by port number\n")
+	fmt.Fprintf(w, "# TYPE meshtastic_packets_received_total counter\n")
+	for _, portnum := range portnums {
+		fmt.Fprintf(w, "meshtastic_packets_received_total{portnum=\"%s\"} %d\n", portnum, m.packets[portnum])
+	}
+}
+
+// escapeLabel escapes a Prometheus label value
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
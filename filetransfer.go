@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// File transfer message types. Each message starts with its type and the transfer ID
+const (
+	// fileOffer is sent by the sender to start or resume a transfer, with the file size, chunk size,
+	// SHA-256 and name
+	fileOffer byte = iota + 1
+	// fileChunk is a chunk of the file with its index
+	fileChunk
+	// fileStatus is sent by the receiver with ranges of chunks it's missing
+	fileStatus
+	// fileQuery is sent by the sender after sending chunks to ask what's still missing
+	fileQuery
+	// fileComplete is sent by the receiver once it has every chunk, saying whether the checksum matched
+	fileComplete
+)
+
+// fileHeaderLen is the length of the type and transfer ID at the start of each message
+const fileHeaderLen = 5
+
+// fileChunkSize is the number of bytes of the file in each chunk
+const fileChunkSize = int(gomeshproto.Constants_DATA_PAYLOAD_LEN) - fileHeaderLen - 2
+
+// fileMaxRanges is the most missing ranges that fit in a status message
+const fileMaxRanges = (int(gomeshproto.Constants_DATA_PAYLOAD_LEN) - fileHeaderLen) / 4
+
+// airtimeReadingAge is how long a reading of our transmit airtime is trusted for
+const airtimeReadingAge = 10 * time.Minute
+
+// fileMessage is a decoded file transfer message
+type fileMessage struct {
+	kind byte
+	id   uint32
+	body []byte
+}
+
+// fileTransfer is the state of a transfer being received. It's saved next to the partly received file so
+// a transfer can be resumed after either end restarts
+type fileTransfer struct {
+	ID       uint32 `json:"id"`
+	Name     string `json:"name"`
+	Size     uint32 `json:"size"`
+	Chunks   int    `json:"chunks"`
+	Sha256   string `json:"sha256"`
+	From     uint32 `json:"from"`
+	Received []bool `json:"received"`
+}
+
+func sendFile(c *cli.Context) error {
+
+	if c.NArg() != 1 {
+		return cli.Exit("File to send is required", 0)
+	}
+	if !c.IsSet("to") {
+		return cli.Exit("--to is required", 0)
+	}
+	to, err := parseNodeID(c.String("to"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	if to == broadcastNum {
+		return cli.Exit("Files can only be sent to a single node", 0)
+	}
+	portNum, err := parsePortNum(c.String("port-num"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	data, err := os.ReadFile(c.Args().First())
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	chunks := (len(data) + fileChunkSize - 1) / fileChunkSize
+	if chunks > 0xffff {
+		return cli.Exit(fmt.Sprintf("File is too large, the most that can be sent is %d bytes", 0xffff*fileChunkSize), 0)
+	}
+
+	sum := sha256.Sum256(data)
+	name := filepath.Base(c.Args().First())
+	maxName := int(gomeshproto.Constants_DATA_PAYLOAD_LEN) - fileHeaderLen - 4 - 2 - len(sum)
+	if len(name) > maxName {
+		return cli.Exit(fmt.Sprintf("File name is too long, it must be under %d bytes", maxName), 0)
+	}
+	// The same file and name always get the same ID, so an interrupted transfer can be resumed
+	idSum := sha256.Sum256(append(sum[:], name...))
+	id := binary.BigEndian.Uint32(idSum[:])
+
+	offer := make([]byte, 6, 6+len(sum)+len(name))
+	binary.BigEndian.PutUint32(offer, uint32(len(data)))
+	binary.BigEndian.PutUint16(offer[4:], uint16(fileChunkSize))
+	offer = append(offer, sum[:]...)
+	offer = append(offer, name...)
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	responses, err := radio.GetRadioInfo()
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	// Our own device metrics arrive in the node database and then in telemetry packets from our node
+	var nodeNum uint32
+	var airUtilTx float32
+	var airUtilHeard time.Time
+	for _, response := range responses {
+		if info := response.GetMyInfo(); info != nil {
+			nodeNum = info.MyNodeNum
+		}
+	}
+	for _, response := range responses {
+		if info := response.GetNodeInfo(); info.GetNum() == nodeNum && info.GetDeviceMetrics() != nil {
+			airUtilTx = info.DeviceMetrics.AirUtilTx
+			airUtilHeard = time.Now()
+		}
+	}
+
+	channel := uint32(c.Int64("channel"))
+	send := func(kind byte, body []byte) error {
+		return sendPacket(radio, &gomeshproto.MeshPacket{
+			To:      to,
+			Id:      newPacketID(),
+			Channel: channel,
+			PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+				Decoded: &gomeshproto.Data{
+					Payload: encodeFileMessage(kind, id, body),
+					Portnum: portNum,
+				},
+			},
+		})
+	}
+
+	var reply *fileMessage
+	handler := func(packet *gomeshproto.MeshPacket) error {
+		if packet.From == nodeNum && packet.GetDecoded().GetPortnum() == gomeshproto.PortNum_TELEMETRY_APP {
+			telemetry := gomeshproto.Telemetry{}
+			if err := unmarshalPayload(packet, &telemetry); err == nil && telemetry.GetDeviceMetrics() != nil {
+				airUtilTx = telemetry.GetDeviceMetrics().AirUtilTx
+				airUtilHeard = time.Now()
+			}
+			return nil
+		}
+		if packet.From != to || packet.GetDecoded().GetPortnum() != portNum {
+			return nil
+		}
+		message, err := decodeFileMessage(packet.GetDecoded().Payload)
+		if err != nil || message.id != id || (message.kind != fileStatus && message.kind != fileComplete) {
+			return nil
+		}
+		reply = message
+		return errStopListening
+	}
+
+	// request sends a message and waits for the receiver to reply with a status, sending it again if
+	// there's no reply
+	request := func(kind byte, body []byte) (*fileMessage, error) {
+		for try := 0; try <= c.Int("retries"); try++ {
+			if err := send(kind, body); err != nil {
+				return nil, err
+			}
+			reply = nil
+			if err := listenPackets(radio, time.Now().Add(c.Duration("timeout")), handler); err != nil {
+				return nil, err
+			}
+			if reply != nil {
+				return reply, nil
+			}
+		}
+		return nil, fmt.Errorf("no reply from %s", nodeID(to))
+	}
+
+	fmt.Printf("Sending %s (%d bytes in %d chunks) to %s\n", name, len(data), chunks, nodeID(to))
+	status, err := request(fileOffer, offer)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	sent := 0
+	for status.kind == fileStatus {
+		missing, err := decodeRanges(status.body, chunks)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		if sent == 0 && len(missing) < chunks {
+			fmt.Printf("Resuming, %d of %d chunks already received\n", chunks-len(missing), chunks)
+		}
+
+		for _, index := range missing {
+			// Wait while we're over our share of the airtime, since the radio would otherwise hold
+			// packets back itself to stay within the duty cycle limit. Telemetry is only sent every so
+			// often, so an old reading isn't waited on forever
+			for airUtilTx >= float32(c.Float64("max-airtime")) && time.Since(airUtilHeard) < airtimeReadingAge {
+				fmt.Fprintf(os.Stderr, "Transmit airtime is %.1f%%, waiting for it to drop below %.1f%%\n", airUtilTx, c.Float64("max-airtime"))
+				if err := listenPackets(radio, time.Now().Add(time.Minute), handler); err != nil {
+					return cli.Exit(err, 0)
+				}
+			}
+
+			end := (index + 1) * fileChunkSize
+			if end > len(data) {
+				end = len(data)
+			}
+			body := make([]byte, 2, 2+end-index*fileChunkSize)
+			binary.BigEndian.PutUint16(body, uint16(index))
+			body = append(body, data[index*fileChunkSize:end]...)
+			if err := send(fileChunk, body); err != nil {
+				return cli.Exit(err, 0)
+			}
+			sent++
+			fmt.Printf("\rSent chunk %d of %d", index+1, chunks)
+
+			// Keep reading from the radio between chunks so its queue is drained and telemetry is seen
+			if err := listenPackets(radio, time.Now().Add(c.Duration("interval")), handler); err != nil {
+				return cli.Exit(err, 0)
+			}
+		}
+		fmt.Printf("\n")
+
+		status, err = request(fileQuery, nil)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	if len(status.body) == 0 || status.body[0] != 1 {
+		return cli.Exit(fmt.Sprintf("%s received the file but the checksum didn't match, send it again", nodeID(to)), 0)
+	}
+
+	fmt.Printf("Sent %s, %d chunks sent and checksum verified by %s\n", name, sent, nodeID(to))
+	return nil
+}
+
+func receiveFiles(c *cli.Context) error {
+
+	portNum, err := parsePortNum(c.String("port-num"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	from := uint32(0)
+	if c.IsSet("from") {
+		from, err = parseNodeID(c.String("from"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+	dir := c.String("dir")
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return cli.Exit(fmt.Sprintf("%s is not a directory", dir), 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	transfers := make(map[uint32]*fileTransfer)
+	completed := make(map[uint32]bool)
+
+	fmt.Printf("Receiving files into %s\n", dir)
+	err = listenPackets(radio, time.Time{}, func(packet *gomeshproto.MeshPacket) error {
+		if packet.GetDecoded().GetPortnum() != portNum || (from != 0 && packet.From != from) {
+			return nil
+		}
+		message, err := decodeFileMessage(packet.GetDecoded().Payload)
+		if err != nil {
+			return nil
+		}
+
+		reply := func(kind byte, body []byte) error {
+			return sendPacket(radio, &gomeshproto.MeshPacket{
+				To:      packet.From,
+				Id:      newPacketID(),
+				Channel: packet.Channel,
+				PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+					Decoded: &gomeshproto.Data{
+						Payload: encodeFileMessage(kind, message.id, body),
+						Portnum: portNum,
+					},
+				},
+			})
+		}
+
+		if completed[message.id] {
+			if message.kind == fileOffer || message.kind == fileQuery {
+				return reply(fileComplete, []byte{1})
+			}
+			return nil
+		}
+
+		transfer, ok := transfers[message.id]
+		if !ok && message.kind != fileOffer {
+			// The transfer may have been started before a restart, in which case its state was saved
+			transfer, err = loadFileTransfer(dir, message.id)
+			if err != nil {
+				return nil
+			}
+			transfers[message.id] = transfer
+		}
+
+		switch message.kind {
+		case fileOffer:
+			if !ok {
+				transfer, err = offeredTransfer(dir, message, packet.From, c.Int64("max-size"))
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Ignoring offer from %s: %v\n", nodeID(packet.From), err)
+					return nil
+				}
+				transfers[message.id] = transfer
+				if err := transfer.save(dir); err != nil {
+					return err
+				}
+				fmt.Printf("Receiving %s (%d bytes) from %s\n", transfer.Name, transfer.Size, nodeID(packet.From))
+			}
+			return reply(fileStatus, encodeRanges(transfer.Received))
+		case fileChunk:
+			if len(message.body) < 2 {
+				return nil
+			}
+			index := int(binary.BigEndian.Uint16(message.body))
+			if index >= transfer.Chunks || transfer.Received[index] {
+				return nil
+			}
+			if err := transfer.writeChunk(dir, index, message.body[2:]); err != nil {
+				return err
+			}
+			return transfer.save(dir)
+		case fileQuery:
+			for _, received := range transfer.Received {
+				if !received {
+					return reply(fileStatus, encodeRanges(transfer.Received))
+				}
+			}
+
+			path, err := transfer.finish(dir)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to receive %s, starting again: %v\n", transfer.Name, err)
+				if err := transfer.save(dir); err != nil {
+					return err
+				}
+				return reply(fileComplete, []byte{0})
+			}
+
+			delete(transfers, message.id)
+			completed[message.id] = true
+			fmt.Printf("Received %s from %s\n", path, nodeID(packet.From))
+			if err := reply(fileComplete, []byte{1}); err != nil {
+				return err
+			}
+			if c.Bool("once") {
+				return errStopListening
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+// encodeFileMessage builds the payload of a file transfer message
+func encodeFileMessage(kind byte, id uint32, body []byte) []byte {
+	payload := make([]byte, fileHeaderLen, fileHeaderLen+len(body))
+	payload[0] = kind
+	binary.BigEndian.PutUint32(payload[1:], id)
+	return append(payload, body...)
+}
+
+// decodeFileMessage splits the payload of a file transfer message into its type, transfer ID and body
+func decodeFileMessage(payload []byte) (*fileMessage, error) {
+	if len(payload) < fileHeaderLen || payload[0] < fileOffer || payload[0] > fileComplete {
+		return nil, errors.New("not a file transfer message")
+	}
+
+	return &fileMessage{kind: payload[0], id: binary.BigEndian.Uint32(payload[1:]), body: payload[fileHeaderLen:]}, nil
+}
+
+// encodeRanges lists the ranges of chunks that haven't been received as a start index and length, as
+// many as fit in a status message
+func encodeRanges(received []bool) []byte {
+	body := make([]byte, 0)
+	i := 0
+	for i < len(received) && len(body)/4 < fileMaxRanges {
+		if received[i] {
+			i++
+			continue
+		}
+		// A range that reaches the longest length is followed by another starting at the next chunk
+		start := i
+		for i < len(received) && !received[i] && i-start < 0xffff {
+			i++
+		}
+		body = append(body, byte(start>>8), byte(start), byte((i-start)>>8), byte(i-start))
+	}
+
+	return body
+}
+
+// decodeRanges returns the chunk indexes in the missing ranges of a status message
+func decodeRanges(body []byte, chunks int) ([]int, error) {
+	if len(body)%4 != 0 {
+		return nil, errors.New("invalid status from receiver")
+	}
+
+	missing := make([]int, 0)
+	for i := 0; i < len(body); i += 4 {
+		start := int(binary.BigEndian.Uint16(body[i:]))
+		count := int(binary.BigEndian.Uint16(body[i+2:]))
+		if start+count > chunks {
+			return nil, errors.New("receiver asked for chunks past the end of the file")
+		}
+		for index := start; index < start+count; index++ {
+			missing = append(missing, index)
+		}
+	}
+
+	return missing, nil
+}
+
+// offeredTransfer starts receiving the file in an offer, or resumes it if it was partly received before.
+// Files over maxSize bytes, or with more chunks than a chunk index can number, are refused
+func offeredTransfer(dir string, offer *fileMessage, from uint32, maxSize int64) (*fileTransfer, error) {
+	if len(offer.body) < 4+2+sha256.Size+1 {
+		return nil, errors.New("invalid offer")
+	}
+	if chunkSize := int(binary.BigEndian.Uint16(offer.body[4:])); chunkSize != fileChunkSize {
+		return nil, fmt.Errorf("chunk size %d doesn't match ours of %d, both ends need the same version", chunkSize, fileChunkSize)
+	}
+
+	name := filepath.Base(string(offer.body[4+2+sha256.Size:]))
+	if name == "." || name == ".." || name == string(filepath.Separator) {
+		return nil, fmt.Errorf("invalid file name %q", name)
+	}
+
+	size := binary.BigEndian.Uint32(offer.body)
+	if int64(size) > maxSize {
+		return nil, fmt.Errorf("%s is %d bytes, over the limit of %d", name, size, maxSize)
+	}
+	chunks := (int(size) + fileChunkSize - 1) / fileChunkSize
+	if chunks > 0xffff {
+		return nil, fmt.Errorf("%s is %d bytes, the most that can be sent is %d", name, size, 0xffff*fileChunkSize)
+	}
+
+	if transfer, err := loadFileTransfer(dir, offer.id); err == nil {
+		return transfer, nil
+	}
+
+	return &fileTransfer{
+		ID:       offer.id,
+		Name:     name,
+		Size:     size,
+		Chunks:   chunks,
+		Sha256:   hex.EncodeToString(offer.body[6 : 6+sha256.Size]),
+		From:     from,
+		Received: make([]bool, chunks),
+	}, nil
+}
+
+// transferPath returns the path of a file kept for a transfer in progress
+func transferPath(dir string, id uint32, ext string) string {
+	return filepath.Join(dir, fmt.Sprintf(".meshtastic-%08x%s", id, ext))
+}
+
+// loadFileTransfer loads the saved state of a transfer in progress
+func loadFileTransfer(dir string, id uint32) (*fileTransfer, error) {
+	data, err := os.ReadFile(transferPath(dir, id, ".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	transfer := &fileTransfer{}
+	if err := json.Unmarshal(data, transfer); err != nil {
+		return nil, err
+	}
+	if len(transfer.Received) != transfer.Chunks {
+		return nil, errors.New("invalid transfer state")
+	}
+
+	return transfer, nil
+}
+
+func (t *fileTransfer) save(dir string) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(transferPath(dir, t.ID, ".json"), data, 0600)
+}
+
+// writeChunk writes a chunk into the partly received file
+func (t *fileTransfer) writeChunk(dir string, index int, data []byte) error {
+	f, err := os.OpenFile(transferPath(dir, t.ID, ".part"), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteAt(data, int64(index*fileChunkSize)); err != nil {
+		return err
+	}
+	t.Received[index] = true
+
+	return nil
+}
+
+// finish checks the received file against its checksum and moves it into place, returning its path. If
+// the checksum doesn't match, every chunk is marked as missing so the file is sent again
+func (t *fileTransfer) finish(dir string) (string, error) {
+	part := transferPath(dir, t.ID, ".part")
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_RDONLY, 0600)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	_, err = io.Copy(hash, io.LimitReader(f, int64(t.Size)))
+	f.Close()
+	if err != nil {
+		return "", err
+	}
+
+	expected, _ := hex.DecodeString(t.Sha256)
+	if !bytes.Equal(hash.Sum(nil), expected) {
+		t.Received = make([]bool, t.Chunks)
+		os.Remove(part)
+		return "", errors.New("checksum doesn't match")
+	}
+
+	if err := os.Truncate(part, int64(t.Size)); err != nil {
+		return "", err
+	}
+
+	// Don't overwrite a file that's already there
+	path := filepath.Join(dir, t.Name)
+	ext := filepath.Ext(t.Name)
+	for i := 1; fileExists(path); i++ {
+		path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", t.Name[:len(t.Name)-len(ext)], i, ext))
+	}
+	if err := os.Rename(part, path); err != nil {
+		return "", err
+	}
+	os.Remove(transferPath(dir, t.ID, ".json"))
+
+	return path, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRangesRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		received []bool
+	}{
+		{"none received", make([]bool, 10)},
+		{"all received", []bool{true, true, true}},
+		{"gaps", []bool{false, true, true, false, false, true, false}},
+		{"ends missing", []bool{true, false, false, true, true, false}},
+		{"longest range", make([]bool, 0xffff)},
+	}
+
+	for _, test := range tests {
+		want := make([]int, 0)
+		for i, received := range test.received {
+			if !received {
+				want = append(want, i)
+			}
+		}
+
+		missing, err := decodeRanges(encodeRanges(test.received), len(test.received))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(missing, want) {
+			t.Errorf("%s: decoded %d missing chunks, want %d", test.name, len(missing), len(want))
+		}
+	}
+}
+
+func TestEncodeRangesPastLongestRange(t *testing.T) {
+	// Transfers can't have this many chunks, but a range longer than a status can describe must carry on
+	// from the chunk after it rather than skipping one
+	received := make([]bool, 70000)
+	body := encodeRanges(received)
+	want := []byte{0x00, 0x00, 0xff, 0xff, 0xff, 0xff, 0x11, 0x71}
+	if !reflect.DeepEqual(body, want) {
+		t.Errorf("encoded as % x, want % x", body, want)
+	}
+}
+
+func TestEncodeRangesLimit(t *testing.T) {
+	// Every other chunk missing makes more ranges than fit in a status message
+	received := make([]bool, 4*fileMaxRanges)
+	for i := range received {
+		received[i] = i%2 == 1
+	}
+
+	missing, err := decodeRanges(encodeRanges(received), len(received))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != fileMaxRanges || missing[len(missing)-1] != 2*(fileMaxRanges-1) {
+		t.Errorf("status lists %d chunks ending at %d, want the first %d", len(missing), missing[len(missing)-1], fileMaxRanges)
+	}
+}
+
+func TestDecodeRangesErrors(t *testing.T) {
+	for _, body := range [][]byte{{0, 1, 0}, {0, 8, 0, 3}} {
+		if _, err := decodeRanges(body, 10); err == nil {
+			t.Errorf("% x decoded without an error", body)
+		}
+	}
+}
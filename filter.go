@@ -0,0 +1,391 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// packetFilter is a parsed filter expression such as `from=!a1b2c3d4 && snr>-10 && text~"alert"` that
+// decoded packets are matched against
+type packetFilter struct {
+	root filterNode
+}
+
+// filterNode is one part of a filter expression
+type filterNode interface {
+	match(packet *gomeshproto.MeshPacket) bool
+}
+
+type filterAnd struct{ left, right filterNode }
+type filterOr struct{ left, right filterNode }
+type filterNot struct{ node filterNode }
+
+// filterCompare compares a packet field with a value
+type filterCompare struct {
+	field  filterField
+	op     string
+	number float64
+	text   string
+	re     *regexp.Regexp
+}
+
+// filterField is a packet field that can be used in a filter. Every field has a text form for matching
+// with ~, and numeric fields also have a number for comparing with < and >. number returns false if the
+// packet doesn't have the field, which makes any comparison with it false
+type filterField struct {
+	numeric bool
+	parse   func(value string) (float64, error)
+	number  func(packet *gomeshproto.MeshPacket) (float64, bool)
+	text    func(packet *gomeshproto.MeshPacket) string
+}
+
+// filterFields are the fields filters can use
+var filterFields = map[string]filterField{
+	"from": nodeField(func(packet *gomeshproto.MeshPacket) uint32 { return packet.From }),
+	"to":   nodeField(func(packet *gomeshproto.MeshPacket) uint32 { return packet.To }),
+	"channel": numberField(func(packet *gomeshproto.MeshPacket) (float64, bool) {
+		return float64(packet.Channel), true
+	}),
+	"port": {
+		numeric: true,
+		parse: func(value string) (float64, error) {
+			num, err := parsePortNum(value)
+			return float64(num), err
+		},
+		number: func(packet *gomeshproto.MeshPacket) (float64, bool) {
+			return float64(packet.GetDecoded().GetPortnum()), true
+		},
+		text: func(packet *gomeshproto.MeshPacket) string {
+			return packet.GetDecoded().GetPortnum().String()
+		},
+	},
+	"snr": numberField(func(packet *gomeshproto.MeshPacket) (float64, bool) {
+		return widenFloat(packet.RxSnr), packet.RxSnr != 0 || packet.RxRssi != 0
+	}),
+	"rssi": numberField(func(packet *gomeshproto.MeshPacket) (float64, bool) {
+		return float64(packet.RxRssi), packet.RxRssi != 0
+	}),
+	"hops": numberField(func(packet *gomeshproto.MeshPacket) (float64, bool) {
+		return float64(packet.HopStart) - float64(packet.HopLimit), packet.HopStart != 0
+	}),
+	"id": numberField(func(packet *gomeshproto.MeshPacket) (float64, bool) {
+		return float64(packet.Id), true
+	}),
+	"reply_id": numberField(func(packet *gomeshproto.MeshPacket) (float64, bool) {
+		return float64(packet.GetDecoded().GetReplyId()), true
+	}),
+	"text": {
+		text: func(packet *gomeshproto.MeshPacket) string {
+			if packet.GetDecoded().GetPortnum() != gomeshproto.PortNum_TEXT_MESSAGE_APP {
+				return ""
+			}
+			return string(packet.GetDecoded().GetPayload())
+		},
+	},
+}
+
+// numberField is a numeric packet field whose values are written as numbers
+func numberField(number func(packet *gomeshproto.MeshPacket) (float64, bool)) filterField {
+	return filterField{
+		numeric: true,
+		parse: func(value string) (float64, error) {
+			return strconv.ParseFloat(value, 64)
+		},
+		number: number,
+		text: func(packet *gomeshproto.MeshPacket) string {
+			value, _ := number(packet)
+			return strconv.FormatFloat(value, 'f', -1, 64)
+		},
+	}
+}
+
+// nodeField is a node number field whose values are written as node IDs, node numbers or "all"
+func nodeField(num func(packet *gomeshproto.MeshPacket) uint32) filterField {
+	return filterField{
+		numeric: true,
+		parse: func(value string) (float64, error) {
+			node, err := parseNodeID(value)
+			return float64(node), err
+		},
+		number: func(packet *gomeshproto.MeshPacket) (float64, bool) {
+			return float64(num(packet)), true
+		},
+		text: func(packet *gomeshproto.MeshPacket) string {
+			return nodeID(num(packet))
+		},
+	}
+}
+
+// filterFromFlags parses the --filter flag of a streaming command. If the command's --channel flag is
+// set, only packets on that channel match as well
+func filterFromFlags(c *cli.Context) (*packetFilter, error) {
+	expr := c.String("filter")
+	if c.IsSet("channel") {
+		channel := fmt.Sprintf("channel=%d", c.Int64("channel"))
+		if strings.TrimSpace(expr) == "" {
+			expr = channel
+		} else {
+			expr = channel + " && (" + expr + ")"
+		}
+	}
+
+	return parseFilter(expr)
+}
+
+// parseFilter parses a filter expression. Comparisons are written as field, operator and value, such as
+// snr>-10, and combined with &&, || and !, with parentheses for grouping. An empty expression matches
+// every packet
+func parseFilter(expr string) (*packetFilter, error) {
+	p := &filterParser{input: expr}
+	p.skipSpace()
+	if p.pos == len(p.input) {
+		return &packetFilter{}, nil
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+
+	return &packetFilter{root: root}, nil
+}
+
+// match reports whether a packet matches the filter
+func (f *packetFilter) match(packet *gomeshproto.MeshPacket) bool {
+	if f == nil || f.root == nil {
+		return true
+	}
+	return f.root.match(packet)
+}
+
+func (n filterAnd) match(packet *gomeshproto.MeshPacket) bool {
+	return n.left.match(packet) && n.right.match(packet)
+}
+
+func (n filterOr) match(packet *gomeshproto.MeshPacket) bool {
+	return n.left.match(packet) || n.right.match(packet)
+}
+
+func (n filterNot) match(packet *gomeshproto.MeshPacket) bool {
+	return !n.node.match(packet)
+}
+
+func (n filterCompare) match(packet *gomeshproto.MeshPacket) bool {
+	switch n.op {
+	case "~":
+		return n.re.MatchString(n.field.text(packet))
+	case "!~":
+		return !n.re.MatchString(n.field.text(packet))
+	}
+
+	if !n.field.numeric {
+		text := n.field.text(packet)
+		if n.op == "=" {
+			return text == n.text
+		}
+		return text != n.text
+	}
+
+	value, ok := n.field.number(packet)
+	if !ok {
+		return false
+	}
+	switch n.op {
+	case "=":
+		return value == n.number
+	case "!=":
+		return value != n.number
+	case "<":
+		return value < n.number
+	case "<=":
+		return value <= n.number
+	case ">":
+		return value > n.number
+	case ">=":
+		return value >= n.number
+	}
+
+	return false
+}
+
+// filterOps are the comparison operators, longest first so that <= isn't read as <
+var filterOps = []string{"<=", ">=", "!=", "!~", "==", "=", "<", ">", "~"}
+
+// filterParser is a recursive descent parser for filter expressions
+type filterParser struct {
+	input string
+	pos   int
+}
+
+func (p *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid filter at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *filterParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips past token if it comes next
+func (p *filterParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.input[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterOr{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.consume("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = filterAnd{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterNode, error) {
+	if p.consume("!") {
+		node, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node}, nil
+	}
+
+	if p.consume("(") {
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume(")") {
+			return nil, p.errorf("missing )")
+		}
+		return node, nil
+	}
+
+	return p.parseCompare()
+}
+
+func (p *filterParser) parseCompare() (filterNode, error) {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.input) && (p.input[p.pos] == '_' || unicode.IsLetter(rune(p.input[p.pos]))) {
+		p.pos++
+	}
+	name := p.input[start:p.pos]
+	if name == "" {
+		return nil, p.errorf("expected a field name")
+	}
+	field, ok := filterFields[name]
+	if !ok {
+		p.pos = start
+		return nil, p.errorf("unknown field %q, expected one of from, to, channel, port, snr, rssi, hops, id, reply_id or text", name)
+	}
+
+	op := ""
+	for _, candidate := range filterOps {
+		if p.consume(candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, p.errorf("expected a comparison after %s", name)
+	}
+	if op == "==" {
+		op = "="
+	}
+
+	p.skipSpace()
+	valueStart := p.pos
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+
+	compare := filterCompare{field: field, op: op, text: value}
+	switch {
+	case op == "~" || op == "!~":
+		compare.re, err = regexp.Compile(value)
+		if err != nil {
+			p.pos = valueStart
+			return nil, p.errorf("invalid regular expression: %v", err)
+		}
+	case field.numeric:
+		compare.number, err = field.parse(value)
+		if err != nil {
+			p.pos = valueStart
+			return nil, p.errorf("invalid value %q for %s", value, name)
+		}
+	case op != "=" && op != "!=":
+		p.pos = valueStart
+		return nil, p.errorf("%s can only be compared with =, != or ~", name)
+	}
+
+	return compare, nil
+}
+
+// parseValue reads a double quoted string, or a bare value up to the next space, parenthesis or operator.
+// Inside quotes only \" and \\ are escapes, so regular expressions such as "\d+" can be written as they are
+func (p *filterParser) parseValue() (string, error) {
+	if p.pos < len(p.input) && p.input[p.pos] == '"' {
+		var value strings.Builder
+		for end := p.pos + 1; end < len(p.input); end++ {
+			switch {
+			case p.input[end] == '"':
+				p.pos = end + 1
+				return value.String(), nil
+			case p.input[end] == '\\' && end+1 < len(p.input) && (p.input[end+1] == '"' || p.input[end+1] == '\\'):
+				end++
+			}
+			value.WriteByte(p.input[end])
+		}
+		return "", p.errorf("unterminated string")
+	}
+
+	start := p.pos
+	for p.pos < len(p.input) && !unicode.IsSpace(rune(p.input[p.pos])) && !strings.ContainsRune("()&|", rune(p.input[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a value")
+	}
+
+	return p.input[start:p.pos], nil
+}
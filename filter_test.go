@@ -0,0 +1,186 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// testPacket is a text message from !a1b2c3d4 to everyone on channel 1, heard directly with an SNR of -5
+func testPacket() *gomeshproto.MeshPacket {
+	return &gomeshproto.MeshPacket{
+		Id:       1234,
+		From:     0xa1b2c3d4,
+		To:       broadcastNum,
+		Channel:  1,
+		RxSnr:    -5,
+		RxRssi:   -90,
+		HopStart: 3,
+		HopLimit: 3,
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Portnum: gomeshproto.PortNum_TEXT_MESSAGE_APP,
+				Payload: []byte(`fire "alert" at 12:00`),
+				ReplyId: 99,
+			},
+		},
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"", true},
+		{"   ", true},
+
+		// Fields
+		{"from=!a1b2c3d4", true},
+		{"from=0xa1b2c3d4", true},
+		{"from!=!a1b2c3d4", false},
+		{"to=all", true},
+		{"to=^all", true},
+		{"to=!00000001", false},
+		{"channel=1", true},
+		{"channel==1", true},
+		{"channel==2", false},
+		{"port=TEXT_MESSAGE_APP", true},
+		{"port=1", true},
+		{"port~TEXT", true},
+		{"snr>-10", true},
+		{"snr<=-5", true},
+		{"snr>-5", false},
+		{"rssi<-80", true},
+		{"hops=0", true},
+		{"id>=1234", true},
+		{"reply_id=99", true},
+		{"text~alert", true},
+		{"text!~alert", false},
+		{"text~^fire", true},
+		{`text~"\d+:\d+"`, true},
+		{`text~"\"alert\""`, true},
+		{`text="fire \"alert\" at 12:00"`, true},
+		{`text="fire"`, false},
+
+		// ! binds tighter than &&, which binds tighter than ||
+		{"!channel=2 && snr>-10", true},
+		{"!(channel=1 && snr>-10)", false},
+		{"channel=2 && snr>-10 || text~alert", true},
+		{"channel=2 && (snr>-10 || text~alert)", false},
+		{"text~alert || channel=2 && snr>0", true},
+		{"(text~alert || channel=2) && snr>0", false},
+		{"!!channel=1", true},
+		{"!channel=1 || !snr>0", true},
+	}
+
+	for _, test := range tests {
+		filter, err := parseFilter(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if got := filter.match(testPacket()); got != test.want {
+			t.Errorf("%s matched %v, want %v", test.expr, got, test.want)
+		}
+	}
+}
+
+func TestFilterMissingFields(t *testing.T) {
+	// Packets sent by our own node have no SNR, RSSI or hop count, so no comparison with them is true
+	packet := testPacket()
+	packet.RxSnr = 0
+	packet.RxRssi = 0
+	packet.HopStart = 0
+	packet.HopLimit = 0
+
+	for _, expr := range []string{"snr>-10", "snr<=0", "snr=0", "snr!=5", "rssi<0", "hops=0", "hops>=0", "hops!=3"} {
+		filter, err := parseFilter(expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		if filter.match(packet) {
+			t.Errorf("%s matched a packet without the field", expr)
+		}
+	}
+
+	// Text is empty for packets that aren't text messages
+	packet.GetDecoded().Portnum = gomeshproto.PortNum_PRIVATE_APP
+	filter, err := parseFilter("text~alert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.match(packet) {
+		t.Errorf("text matched a packet that isn't a text message")
+	}
+}
+
+func TestFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"snr>", "position 5: expected a value"},
+		{"name=bob", `position 1: unknown field "name"`},
+		{"snr", "position 4: expected a comparison after snr"},
+		{"snr>-10 &&", "position 11: expected a field name"},
+		{"(snr>-10", "position 9: missing )"},
+		{"snr>-10)", `position 8: unexpected ")"`},
+		{"snr>loud", `position 5: invalid value "loud" for snr`},
+		{"from=!zz", `position 6: invalid value "!zz" for from`},
+		{"port=NOT_A_PORT", `position 6: invalid value "NOT_A_PORT" for port`},
+		{`text~"("`, "position 6: invalid regular expression"},
+		{"text<3", "position 6: text can only be compared with =, != or ~"},
+		{`text="alert`, "position 6: unterminated string"},
+	}
+
+	for _, test := range tests {
+		_, err := parseFilter(test.expr)
+		if err == nil {
+			t.Errorf("%s parsed without an error", test.expr)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %q, want %q", test.expr, err, test.want)
+		}
+	}
+}
+
+func TestFilterFromFlags(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{}, true},
+		{[]string{"--channel", "1"}, true},
+		{[]string{"--channel", "2"}, false},
+		{[]string{"--filter", "snr>-10"}, true},
+		{[]string{"--channel", "1", "--filter", "snr>-10"}, true},
+		{[]string{"--channel", "2", "--filter", "snr>-10"}, false},
+		// The filter is grouped so --channel applies to both sides of ||
+		{[]string{"--channel", "2", "--filter", "snr>-10 || text~alert"}, false},
+		{[]string{"--channel", "1", "--filter", "snr>0 || text~alert"}, true},
+	}
+
+	for _, test := range tests {
+		set := flag.NewFlagSet("test", flag.ContinueOnError)
+		set.String("filter", "", "")
+		set.Int64("channel", 0, "")
+		if err := set.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+
+		filter, err := filterFromFlags(cli.NewContext(cli.NewApp(), set, nil))
+		if err != nil {
+			t.Errorf("%v: %v", test.args, err)
+			continue
+		}
+		if got := filter.match(testPacket()); got != test.want {
+			t.Errorf("%v matched %v, want %v", test.args, got, test.want)
+		}
+	}
+}
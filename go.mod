@@ -3,8 +3,11 @@ module github.com/lmatte7/meshtastic-go
 go 1.16
 
 require (
-	github.com/golang/protobuf v1.5.0
+	github.com/golang/protobuf v1.5.2
+	github.com/jacobsa/go-serial v0.0.0-20180131005756-15cf729a72d4
 	github.com/lmatte7/gomesh v0.2.1
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/urfave/cli/v2 v2.3.0
-	google.golang.org/protobuf v1.26.0
+	google.golang.org/grpc v1.45.0
+	google.golang.org/protobuf v1.27.1
 )
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jacobsa/go-serial/serial"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// gpsFix is a position reported by a GPS receiver
+type gpsFix struct {
+	Lat   float64
+	Lon   float64
+	Alt   float64
+	Time  time.Time
+	Sats  uint32
+	Speed float64
+	Track float64
+}
+
+func followLocation(c *cli.Context) error {
+
+	if c.IsSet("gpsd") == c.IsSet("nmea") {
+		return cli.Exit("Either --gpsd or --nmea is required", 0)
+	}
+
+	var source io.ReadCloser
+	var err error
+	if c.IsSet("gpsd") {
+		source, err = openGpsd(c.String("gpsd"))
+	} else {
+		source, err = openNMEA(c.String("nmea"), c.Int("baud"))
+	}
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	defer source.Close()
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	fixes := make(chan gpsFix)
+	errs := make(chan error, 1)
+	go func() {
+		if c.IsSet("gpsd") {
+			errs <- readGpsd(source, fixes)
+		} else {
+			errs <- readNMEA(source, fixes)
+		}
+		close(fixes)
+	}()
+
+	interval := c.Duration("interval")
+	maxInterval := c.Duration("max-interval")
+	minDistance := c.Float64("distance")
+
+	var lastSent *gpsFix
+	sent := 0
+	for fix := range fixes {
+		if lastSent != nil {
+			elapsed := fix.Time.Sub(lastSent.Time)
+			moved := distanceMeters(lastSent.Lat, lastSent.Lon, fix.Lat, fix.Lon)
+
+			// Only send a position once the interval has passed and the radio has moved far enough,
+			// unless the max interval has passed so the position on the mesh doesn't go stale
+			if elapsed < interval || (moved < minDistance && elapsed < maxInterval) {
+				continue
+			}
+		}
+
+		position := &gomeshproto.Position{
+			LatitudeI:      degreesToInt(fix.Lat),
+			LongitudeI:     degreesToInt(fix.Lon),
+			Altitude:       int32(math.Round(fix.Alt)),
+			Time:           uint32(fix.Time.Unix()),
+			LocationSource: gomeshproto.Position_LOC_EXTERNAL,
+			SatsInView:     fix.Sats,
+			GroundSpeed:    uint32(math.Round(fix.Speed)),
+			GroundTrack:    uint32(math.Round(fix.Track * 100)),
+		}
+
+		err := radio.SetLocation(position.LatitudeI, position.LongitudeI, position.Altitude)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+
+		if c.Bool("broadcast") {
+			err = broadcastPosition(radio, position, uint32(c.Uint("precision")), uint32(c.Int64("channel")))
+			if err != nil {
+				return cli.Exit(err, 0)
+			}
+		}
+
+		fmt.Printf("%s Sent position %.7f, %.7f, %.0fm\n", fix.Time.Format(time.RFC3339), fix.Lat, fix.Lon, fix.Alt)
+		sentFix := fix
+		lastSent = &sentFix
+		sent++
+	}
+
+	if err := <-errs; err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("GPS source closed after sending %d positions\n", sent)
+	return nil
+}
+
+// openNMEA opens an NMEA source. Serial ports are opened at the given baud rate, anything else is read
+// as a file so recorded NMEA logs can be replayed
+func openNMEA(path string, baud int) (io.ReadCloser, error) {
+	info, err := os.Stat(path)
+	if err == nil && info.Mode()&os.ModeCharDevice == 0 {
+		return os.Open(path)
+	}
+
+	return serial.Open(serial.OpenOptions{
+		PortName:        path,
+		BaudRate:        uint(baud),
+		DataBits:        8,
+		StopBits:        1,
+		MinimumReadSize: 1,
+		ParityMode:      serial.PARITY_NONE,
+	})
+}
+
+// openGpsd connects to gpsd and asks it to stream JSON reports
+func openGpsd(addr string) (io.ReadCloser, error) {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = conn.Write([]byte(`?WATCH={"enable":true,"json":true};` + "\n"))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
+// readGpsd reads gpsd JSON reports and sends a fix for each TPV report with a 2D or 3D fix
+func readGpsd(r io.Reader, fixes chan<- gpsFix) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		report := struct {
+			Class  string   `json:"class"`
+			Mode   int      `json:"mode"`
+			Time   string   `json:"time"`
+			Lat    float64  `json:"lat"`
+			Lon    float64  `json:"lon"`
+			Alt    *float64 `json:"alt"`
+			AltMSL *float64 `json:"altMSL"`
+			Speed  float64  `json:"speed"`
+			Track  float64  `json:"track"`
+		}{}
+
+		if err := json.Unmarshal(scanner.Bytes(), &report); err != nil || report.Class != "TPV" || report.Mode < 2 {
+			continue
+		}
+
+		fix := gpsFix{
+			Lat:   report.Lat,
+			Lon:   report.Lon,
+			Speed: report.Speed,
+			Track: report.Track,
+			Time:  time.Now(),
+		}
+		if t, err := time.Parse(time.RFC3339, report.Time); err == nil {
+			fix.Time = t
+		}
+		if report.AltMSL != nil {
+			fix.Alt = *report.AltMSL
+		} else if report.Alt != nil {
+			fix.Alt = *report.Alt
+		}
+
+		fixes <- fix
+	}
+
+	return scanner.Err()
+}
+
+// readNMEA reads NMEA sentences and sends a fix for each valid RMC or GGA sentence
+func readNMEA(r io.Reader, fixes chan<- gpsFix) error {
+	parser := nmeaParser{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if fix, ok := parser.parse(scanner.Text()); ok {
+			fixes <- fix
+		}
+	}
+
+	return scanner.Err()
+}
+
+// nmeaParser combines RMC and GGA sentences into fixes. RMC sentences carry the date, speed and track and
+// GGA sentences carry the altitude and satellite count, so the most recent values of each are kept
+type nmeaParser struct {
+	date time.Time
+	alt  float64
+	sats uint32
+}
+
+func (p *nmeaParser) parse(sentence string) (gpsFix, bool) {
+	fields, err := splitNMEA(sentence)
+	if err != nil || len(fields[0]) < 5 {
+		return gpsFix{}, false
+	}
+
+	// Ignore the talker ID so GP, GN, GL and the other constellations are all handled
+	switch fields[0][2:] {
+	case "RMC":
+		if len(fields) < 10 || fields[2] != "A" {
+			return gpsFix{}, false
+		}
+		lat, lon, err := nmeaLatLon(fields[3], fields[4], fields[5], fields[6])
+		if err != nil {
+			return gpsFix{}, false
+		}
+		if date, err := time.Parse("020106", fields[9]); err == nil {
+			p.date = date
+		}
+		knots, _ := strconv.ParseFloat(fields[7], 64)
+		track, _ := strconv.ParseFloat(fields[8], 64)
+
+		return gpsFix{
+			Lat:   lat,
+			Lon:   lon,
+			Alt:   p.alt,
+			Sats:  p.sats,
+			Time:  p.fixTime(fields[1]),
+			Speed: knots * 0.514444,
+			Track: track,
+		}, true
+	case "GGA":
+		if len(fields) < 10 || fields[6] == "" || fields[6] == "0" {
+			return gpsFix{}, false
+		}
+		lat, lon, err := nmeaLatLon(fields[2], fields[3], fields[4], fields[5])
+		if err != nil {
+			return gpsFix{}, false
+		}
+		sats, _ := strconv.ParseUint(fields[7], 10, 32)
+		p.sats = uint32(sats)
+		if alt, err := strconv.ParseFloat(fields[9], 64); err == nil {
+			p.alt = alt
+		}
+
+		// GGA sentences don't include the date, so wait for an RMC sentence before using them
+		if p.date.IsZero() {
+			return gpsFix{}, false
+		}
+
+		return gpsFix{
+			Lat:  lat,
+			Lon:  lon,
+			Alt:  p.alt,
+			Sats: p.sats,
+			Time: p.fixTime(fields[1]),
+		}, true
+	}
+
+	return gpsFix{}, false
+}
+
+// fixTime combines the hhmmss.ss time of a sentence with the last date seen in an RMC sentence
+func (p *nmeaParser) fixTime(value string) time.Time {
+	if len(value) < 6 || p.date.IsZero() {
+		return time.Now().UTC()
+	}
+
+	hours, _ := strconv.Atoi(value[0:2])
+	minutes, _ := strconv.Atoi(value[2:4])
+	seconds, _ := strconv.ParseFloat(value[4:], 64)
+
+	return p.date.Add(time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)))
+}
+
+// splitNMEA verifies the checksum of an NMEA sentence and splits it into its fields
+func splitNMEA(sentence string) ([]string, error) {
+	sentence = strings.TrimSpace(sentence)
+	if !strings.HasPrefix(sentence, "$") {
+		return nil, errors.New("not an NMEA sentence")
+	}
+	sentence = sentence[1:]
+
+	if star := strings.LastIndex(sentence, "*"); star >= 0 {
+		expected, err := strconv.ParseUint(sentence[star+1:], 16, 8)
+		if err != nil {
+			return nil, err
+		}
+		sentence = sentence[:star]
+
+		checksum := byte(0)
+		for i := 0; i < len(sentence); i++ {
+			checksum ^= sentence[i]
+		}
+		if checksum != byte(expected) {
+			return nil, errors.New("invalid NMEA checksum")
+		}
+	}
+
+	return strings.Split(sentence, ","), nil
+}
+
+// nmeaLatLon converts NMEA ddmm.mmmm and dddmm.mmmm coordinates to decimal degrees
+func nmeaLatLon(lat string, latHemisphere string, lon string, lonHemisphere string) (float64, float64, error) {
+	convert := func(value string, degreeDigits int, negative bool) (float64, error) {
+		if len(value) < degreeDigits+2 {
+			return 0, errors.New("invalid NMEA coordinate")
+		}
+		degrees, err := strconv.ParseFloat(value[:degreeDigits], 64)
+		if err != nil {
+			return 0, err
+		}
+		minutes, err := strconv.ParseFloat(value[degreeDigits:], 64)
+		if err != nil {
+			return 0, err
+		}
+		degrees += minutes / 60
+		if negative {
+			degrees = -degrees
+		}
+		return degrees, nil
+	}
+
+	latDegrees, err := convert(lat, 2, latHemisphere == "S")
+	if err != nil {
+		return 0, 0, err
+	}
+	lonDegrees, err := convert(lon, 3, lonHemisphere == "W")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return latDegrees, lonDegrees, nil
+}
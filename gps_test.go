@@ -0,0 +1,117 @@
+package main
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// readNMEAFile returns the fixes readNMEA finds in a file in testdata
+func readNMEAFile(t *testing.T, name string) []gpsFix {
+	file, err := os.Open("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	fixes := make(chan gpsFix)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- readNMEA(file, fixes)
+		close(fixes)
+	}()
+
+	found := make([]gpsFix, 0)
+	for fix := range fixes {
+		found = append(found, fix)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+
+	return found
+}
+
+func TestReadNMEAFixes(t *testing.T) {
+	want := []gpsFix{
+		{
+			Lat:   48.1173,
+			Lon:   11.5166667,
+			Alt:   545.4,
+			Sats:  8,
+			Time:  time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC),
+			Speed: 22.4 * 0.514444,
+			Track: 84.4,
+		},
+		{
+			Lat:  48.1173,
+			Lon:  11.5166667,
+			Alt:  545.4,
+			Sats: 8,
+			Time: time.Date(1994, 3, 23, 12, 35, 20, 0, time.UTC),
+		},
+		{
+			Lat:   -33.85,
+			Lon:   -151.21,
+			Alt:   545.4,
+			Sats:  8,
+			Time:  time.Date(1998, 9, 13, 8, 18, 36, 500000000, time.UTC),
+			Track: 360,
+		},
+	}
+
+	// The GGA sentence before the first RMC sentence has no date, so only its altitude and satellites
+	// are used
+	fixes := readNMEAFile(t, "fix.nmea")
+	if len(fixes) != len(want) {
+		t.Fatalf("got %d fixes, want %d: %+v", len(fixes), len(want), fixes)
+	}
+	for i, fix := range fixes {
+		expected := want[i]
+		if math.Abs(fix.Lat-expected.Lat) > 1e-7 || math.Abs(fix.Lon-expected.Lon) > 1e-7 {
+			t.Errorf("fix %d is at %.7f, %.7f, want %.7f, %.7f", i+1, fix.Lat, fix.Lon, expected.Lat, expected.Lon)
+		}
+		if fix.Alt != expected.Alt || fix.Sats != expected.Sats {
+			t.Errorf("fix %d has altitude %v and %d satellites, want %v and %d", i+1, fix.Alt, fix.Sats, expected.Alt, expected.Sats)
+		}
+		if !fix.Time.Equal(expected.Time) {
+			t.Errorf("fix %d is at %s, want %s", i+1, fix.Time, expected.Time)
+		}
+		if math.Abs(fix.Speed-expected.Speed) > 1e-9 || fix.Track != expected.Track {
+			t.Errorf("fix %d has speed %v and track %v, want %v and %v", i+1, fix.Speed, fix.Track, expected.Speed, expected.Track)
+		}
+	}
+}
+
+func TestReadNMEAChecksums(t *testing.T) {
+	if fixes := readNMEAFile(t, "checksum.nmea"); len(fixes) != 0 {
+		t.Errorf("sentences with bad checksums gave fixes %+v", fixes)
+	}
+}
+
+func TestReadNMEANoFix(t *testing.T) {
+	if fixes := readNMEAFile(t, "nofix.nmea"); len(fixes) != 0 {
+		t.Errorf("sentences without a fix gave fixes %+v", fixes)
+	}
+}
+
+func TestSplitNMEA(t *testing.T) {
+	fields, err := splitNMEA("$GPGSV,3,1,11,03,03,111,00,04,15,270,00,06,01,010,00,13,06,292,00*74\r\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 20 || fields[0] != "GPGSV" || fields[19] != "00" {
+		t.Errorf("split into %q", fields)
+	}
+
+	for _, sentence := range []string{
+		"GPGSV,3,1,11*74",
+		"$GPGSV,3,1,11,03,03,111,00,04,15,270,00,06,01,010,00,13,06,292,00*75",
+		"$GPGSV,3,1,11,03,03,111,00,04,15,270,00,06,01,010,00,13,06,292,00*7G",
+	} {
+		if _, err := splitNMEA(sentence); err == nil {
+			t.Errorf("%s split without an error", sentence)
+		}
+	}
+}
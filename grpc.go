@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"net"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/lmatte7/meshtastic-go/meshrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+// radioServer implements the meshrpc Radio service on top of a mesh session. The service has no
+// authentication, so the calls that change or reset the radio are refused unless admin is set
+type radioServer struct {
+	meshrpc.UnimplementedRadioServer
+	session *meshSession
+	admin   bool
+}
+
+// serveGRPC serves the Radio service on addr until the listener fails
+func serveGRPC(addr string, s *meshSession, admin bool) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	server := grpc.NewServer()
+	meshrpc.RegisterRadioServer(server, &radioServer{session: s, admin: admin})
+
+	return server.Serve(listener)
+}
+
+// requireAdmin returns an error for calls that change or reset the radio if they aren't allowed
+func (s *radioServer) requireAdmin() error {
+	if !s.admin {
+		return status.Error(codes.PermissionDenied, "changing the radio over gRPC needs serve --grpc-admin")
+	}
+	return nil
+}
+
+func (s *radioServer) GetRadioInfo(ctx context.Context, _ *emptypb.Empty) (*meshrpc.RadioInfo, error) {
+	responses, err := s.session.refresh()
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &meshrpc.RadioInfo{Packets: responses}, nil
+}
+
+func (s *radioServer) GetChannels(ctx context.Context, _ *emptypb.Empty) (*meshrpc.Channels, error) {
+	return &meshrpc.Channels{Channels: s.session.channelList()}, nil
+}
+
+func (s *radioServer) SetChannel(ctx context.Context, channel *gomeshproto.Channel) (*emptypb.Empty, error) {
+	if err := s.requireAdmin(); err != nil {
+		return nil, err
+	}
+	if channel.Index < 0 || channel.Index >= maxChannels {
+		return nil, status.Errorf(codes.InvalidArgument, "channel index must be between 0 and %d", maxChannels-1)
+	}
+
+	err := s.session.do(func(r gomesh.Radio) error {
+		return sendAdminMessage(r, s.session.nodeNum, &gomeshproto.AdminMessage{
+			PayloadVariant: &gomeshproto.AdminMessage_SetChannel{
+				SetChannel: channel,
+			},
+		})
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	s.session.storeChannel(channel)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *radioServer) SetRadioConfig(ctx context.Context, config *gomeshproto.Config) (*emptypb.Empty, error) {
+	if err := s.requireAdmin(); err != nil {
+		return nil, err
+	}
+	if config.PayloadVariant == nil {
+		return nil, status.Error(codes.InvalidArgument, "a config section must be set")
+	}
+
+	err := s.session.do(func(r gomesh.Radio) error {
+		return sendAdminMessage(r, s.session.nodeNum, &gomeshproto.AdminMessage{
+			PayloadVariant: &gomeshproto.AdminMessage_SetConfig{
+				SetConfig: config,
+			},
+		})
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	s.session.storeConfig(config)
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *radioServer) SendTextMessage(ctx context.Context, message *meshrpc.TextMessage) (*meshrpc.SentMessage, error) {
+	if message.Text == "" {
+		return nil, status.Error(codes.InvalidArgument, "text is required")
+	}
+
+	to := message.To
+	if to == 0 {
+		to = broadcastNum
+	}
+
+	sent, err := s.session.sendText(message.Text, to, message.Channel, 0)
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &meshrpc.SentMessage{Id: sent.ID}, nil
+}
+
+func (s *radioServer) SetLocation(ctx context.Context, position *gomeshproto.Position) (*emptypb.Empty, error) {
+	err := s.session.do(func(r gomesh.Radio) error {
+		return r.SetLocation(position.LatitudeI, position.LongitudeI, position.Altitude)
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *radioServer) FactoryReset(ctx context.Context, _ *emptypb.Empty) (*emptypb.Empty, error) {
+	if err := s.requireAdmin(); err != nil {
+		return nil, err
+	}
+	err := s.session.do(func(r gomesh.Radio) error {
+		return r.FactoryRest()
+	})
+	if err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &emptypb.Empty{}, nil
+}
+
+func (s *radioServer) Subscribe(_ *emptypb.Empty, stream meshrpc.Radio_SubscribeServer) error {
+	events := s.session.subscribe()
+	defer s.session.unsubscribe(events)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if event.Type != "packet" {
+				continue
+			}
+			if err := stream.Send(event.Packet); err != nil {
+				return err
+			}
+		}
+	}
+}
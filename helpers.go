@@ -1,10 +1,20 @@
 package main
 
 import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
 	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
 )
 
 func getRadio(c *cli.Context) gomesh.Radio {
@@ -16,3 +26,116 @@ func getRadio(c *cli.Context) gomesh.Radio {
 
 	return radio
 }
+
+// nodeID formats a node number the way the meshtastic apps display node IDs
+func nodeID(num uint32) string {
+	return fmt.Sprintf("!%08x", num)
+}
+
+// getNodeNum returns the node number of the connected radio
+func getNodeNum(r gomesh.Radio) (uint32, error) {
+	responses, err := r.GetRadioInfo()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, response := range responses {
+		if info, ok := response.GetPayloadVariant().(*gomeshproto.FromRadio_MyInfo); ok {
+			return info.MyInfo.MyNodeNum, nil
+		}
+	}
+
+	return 0, errors.New("failed to get node number")
+}
+
+// sendPacket sends a mesh packet to the radio
+func sendPacket(r gomesh.Radio, packet *gomeshproto.MeshPacket) error {
+	radioMessage := gomeshproto.ToRadio{
+		PayloadVariant: &gomeshproto.ToRadio_Packet{
+			Packet: packet,
+		},
+	}
+
+	out, err := proto.Marshal(&radioMessage)
+	if err != nil {
+		return err
+	}
+
+	return r.SendPacket(out)
+}
+
+// sendAdminMessage sends an admin message to the node with the given node number
+func sendAdminMessage(r gomesh.Radio, nodeNum uint32, adminPacket *gomeshproto.AdminMessage) error {
+	out, err := proto.Marshal(adminPacket)
+	if err != nil {
+		return err
+	}
+
+	return sendAdminPayload(r, nodeNum, out)
+}
+
+// sendAdminPayload sends an already encoded admin message. This allows admin messages to use fields
+// that aren't in the protobufs included with gomesh
+func sendAdminPayload(r gomesh.Radio, nodeNum uint32, payload []byte) error {
+	return sendPacket(r, &gomeshproto.MeshPacket{
+		To:      nodeNum,
+		WantAck: true,
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Payload:      payload,
+				Portnum:      gomeshproto.PortNum_ADMIN_APP,
+				WantResponse: true,
+			},
+		},
+	})
+}
+
+// unmarshalPayload decodes the payload of a received mesh packet
+func unmarshalPayload(packet *gomeshproto.MeshPacket, m proto.Message) error {
+	return proto.Unmarshal(packet.GetDecoded().GetPayload(), m)
+}
+
+// newPacketID returns a random ID for a packet sent from this radio
+func newPacketID() uint32 {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return binary.BigEndian.Uint32(id)>>1 + 1
+}
+
+// parseNodeID parses a node given as a !hex node ID, a hex or decimal node number, or "all" for broadcast
+func parseNodeID(value string) (uint32, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case value == "all" || value == "^all":
+		return broadcastNum, nil
+	case strings.HasPrefix(value, "!"):
+		num, err := strconv.ParseUint(value[1:], 16, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid node ID %q", value)
+		}
+		return uint32(num), nil
+	}
+
+	num, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid node ID %q", value)
+	}
+
+	return uint32(num), nil
+}
+
+// dataPath returns the path of a file kept between runs, in a meshtastic-go directory under the user's
+// config directory
+func dataPath(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "meshtastic-go")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, name), nil
+}
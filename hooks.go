@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// maxHookJobs is how many commands and webhooks can run at once. Packets that would start more are
+// skipped so a burst of traffic can't pile up processes
+const maxHookJobs = 8
+
+// webhookTimeout is how long a webhook has to respond
+const webhookTimeout = 10 * time.Second
+
+// hookRule maps a filter to the actions to take for packets matching it
+type hookRule struct {
+	Name    string `json:"name"`
+	Filter  string `json:"filter"`
+	Exec    string `json:"exec"`
+	Reply   string `json:"reply"`
+	Webhook string `json:"webhook"`
+	Every   string `json:"every"`
+
+	filter *packetFilter
+	every  time.Duration
+	last   map[uint32]time.Time
+}
+
+// hookRunner runs the actions of the rules matching each received packet
+type hookRunner struct {
+	rules         []*hookRule
+	nodeNum       uint32
+	replyInterval time.Duration
+	lastReply     time.Time
+	execTimeout   time.Duration
+	jobs          chan struct{}
+	client        *http.Client
+}
+
+// newHookRunner builds the rules from the --rules file and the --exec flag, which runs a command for
+// every packet matching --filter. nil is returned if there are no rules
+func newHookRunner(c *cli.Context, r gomesh.Radio, filter *packetFilter) (*hookRunner, error) {
+	rules := make([]*hookRule, 0)
+	if c.IsSet("rules") {
+		loaded, err := loadHookRules(c.String("rules"))
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, loaded...)
+	}
+	if c.IsSet("exec") {
+		rules = append(rules, &hookRule{Name: "exec", Exec: c.String("exec"), filter: filter, last: make(map[uint32]time.Time)})
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	hooks := &hookRunner{
+		rules:         rules,
+		replyInterval: c.Duration("reply-interval"),
+		execTimeout:   c.Duration("exec-timeout"),
+		jobs:          make(chan struct{}, maxHookJobs),
+		client:        &http.Client{Timeout: webhookTimeout},
+	}
+
+	// Replies need our node number so we never reply to ourselves
+	for _, rule := range rules {
+		if rule.Reply != "" {
+			nodeNum, err := getNodeNum(r)
+			if err != nil {
+				return nil, err
+			}
+			hooks.nodeNum = nodeNum
+			break
+		}
+	}
+
+	return hooks, nil
+}
+
+// loadHookRules reads a rules file, a JSON array of rules
+func loadHookRules(path string) ([]*hookRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	rules := make([]*hookRule, 0)
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules file %s: %v", path, err)
+	}
+
+	for i, rule := range rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule %d", i+1)
+		}
+		if rule.Exec == "" && rule.Reply == "" && rule.Webhook == "" {
+			return nil, fmt.Errorf("%s in %s has no exec, reply or webhook action", rule.Name, path)
+		}
+		rule.filter, err = parseFilter(rule.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("%s in %s: %v", rule.Name, path, err)
+		}
+		if rule.Every != "" {
+			rule.every, err = time.ParseDuration(rule.Every)
+			if err != nil {
+				return nil, fmt.Errorf("%s in %s: invalid every %q", rule.Name, path, rule.Every)
+			}
+		}
+		rule.last = make(map[uint32]time.Time)
+	}
+
+	return rules, nil
+}
+
+// handle runs the actions of every rule a packet matches. Replies are sent before returning, while
+// commands and webhooks run in the background
+func (h *hookRunner) handle(r gomesh.Radio, packet *gomeshproto.MeshPacket) {
+	if h == nil {
+		return
+	}
+
+	event := newPacketEvent(packet)
+	for _, rule := range h.rules {
+		rule := rule
+		if !rule.filter.match(packet) {
+			continue
+		}
+		// A rule that only replies has nothing to do for other packets, so they mustn't use up its interval
+		if rule.Exec == "" && rule.Webhook == "" && packet.GetDecoded().GetPortnum() != gomeshproto.PortNum_TEXT_MESSAGE_APP {
+			continue
+		}
+		// Each rule acts at most once per interval for each sender
+		if rule.every > 0 && time.Since(rule.last[packet.From]) < rule.every {
+			continue
+		}
+		rule.last[packet.From] = time.Now()
+
+		if rule.Exec != "" {
+			h.start(rule, func() error { return h.runExec(rule, event) })
+		}
+		if rule.Webhook != "" {
+			h.start(rule, func() error { return h.postWebhook(rule, event) })
+		}
+		if rule.Reply != "" {
+			if err := h.sendReply(r, rule, packet, event); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", rule.Name, err)
+			}
+		}
+	}
+}
+
+// start runs a command or webhook in the background if there's room for another job
+func (h *hookRunner) start(rule *hookRule, job func() error) {
+	select {
+	case h.jobs <- struct{}{}:
+	default:
+		fmt.Fprintf(os.Stderr, "%s: skipped, %d actions are already running\n", rule.Name, maxHookJobs)
+		return
+	}
+
+	go func() {
+		defer func() { <-h.jobs }()
+		if err := job(); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", rule.Name, err)
+		}
+	}()
+}
+
+// runExec runs a rule's command with sh, passing the packet as JSON on stdin and its fields as MESH_
+// environment variables. The command's output goes to stderr so it doesn't mix with --json output
+func (h *hookRunner) runExec(rule *hookRule, event packetEvent) error {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.execTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", rule.Exec)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), eventEnv(rule, event)...)
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%s timed out after %s", rule.Exec, h.execTimeout)
+		}
+		return fmt.Errorf("%s: %v", rule.Exec, err)
+	}
+
+	return nil
+}
+
+// postWebhook posts the packet as JSON to a rule's webhook URL, with the rule's name added
+func (h *hookRunner) postWebhook(rule *hookRule, event packetEvent) error {
+	body, err := json.Marshal(struct {
+		Rule string `json:"rule"`
+		packetEvent
+	}{rule.Name, event})
+	if err != nil {
+		return err
+	}
+
+	response, err := h.client.Post(rule.Webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// sendReply sends a rule's reply to a packet, as a direct message if the packet was sent directly to us
+// or otherwise on the channel it arrived on. MESH_ variables in the reply are replaced with the packet's
+// fields. Only text messages are replied to, so acknowledgements of our own replies can't start a loop.
+// Replies are never sent to ourselves, and at most once per reply interval across all rules
+func (h *hookRunner) sendReply(r gomesh.Radio, rule *hookRule, packet *gomeshproto.MeshPacket, event packetEvent) error {
+	if packet.From == h.nodeNum || packet.GetDecoded().GetPortnum() != gomeshproto.PortNum_TEXT_MESSAGE_APP {
+		return nil
+	}
+	if time.Since(h.lastReply) < h.replyInterval {
+		return fmt.Errorf("reply skipped, the last automatic reply was under %s ago", h.replyInterval)
+	}
+
+	env := make(map[string]string)
+	for _, variable := range eventEnv(rule, event) {
+		parts := strings.SplitN(variable, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	text := os.Expand(rule.Reply, func(name string) string { return env[name] })
+	text = truncateText(text, textBudget(packet.Id, 0, false))
+
+	to := uint32(broadcastNum)
+	if packet.To == h.nodeNum {
+		to = packet.From
+	}
+
+	h.lastReply = time.Now()
+	if _, err := sendTextMessage(r, text, to, packet.Channel, packet.Id, 0, nil); err != nil {
+		return fmt.Errorf("reply: %v", err)
+	}
+	return nil
+}
+
+// eventEnv returns the fields of a packet as MESH_ environment variables
+func eventEnv(rule *hookRule, event packetEvent) []string {
+	hops := ""
+	if event.Hops != nil {
+		hops = fmt.Sprint(*event.Hops)
+	}
+
+	return []string{
+		"MESH_RULE=" + rule.Name,
+		fmt.Sprintf("MESH_ID=%d", event.ID),
+		"MESH_FROM=" + event.From,
+		"MESH_TO=" + event.To,
+		fmt.Sprintf("MESH_CHANNEL=%d", event.Channel),
+		"MESH_PORT=" + event.Port,
+		fmt.Sprintf("MESH_SNR=%v", event.Snr),
+		fmt.Sprintf("MESH_RSSI=%d", event.Rssi),
+		"MESH_HOPS=" + hops,
+		fmt.Sprintf("MESH_REPLY_ID=%d", event.ReplyID),
+		"MESH_TEXT=" + strings.ReplaceAll(event.Text, "\x00", ""),
+		"MESH_TIME=" + event.Time.Format(time.RFC3339),
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"reflect"
 
 	"github.com/golang/protobuf/jsonpb"
@@ -39,6 +40,10 @@ func showNodeInfo(c *cli.Context) error {
 	radio := getRadio(c)
 	defer radio.Close()
 
+	if c.IsSet("export") {
+		return exportNodes(c, radio)
+	}
+
 	return displayNodes(radio)
 }
 
@@ -92,7 +97,17 @@ func displayNodes(r gomesh.Radio) error {
 		}
 	}
 
-	printNodes(nodes)
+	infos := make([]*gomeshproto.NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		infos = append(infos, node.NodeInfo)
+	}
+	// The key check is extra, so the nodes are still shown without it if the known keys can't be read
+	keys, err := checkNodeKeys(infos)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Not checking node keys: %v\n", err)
+	}
+
+	printNodes(nodes, keys)
 
 	return nil
 }
@@ -139,7 +154,9 @@ func setModemOption(c *cli.Context) error {
 	return nil
 }
 
-func printNodes(nodes []*gomeshproto.FromRadio_NodeInfo) {
+// printNodes prints the node database. The key column shows the fingerprint of each node's public key,
+// marked if it has changed since it was first seen
+func printNodes(nodes []*gomeshproto.FromRadio_NodeInfo, keys map[uint32]keyStatus) {
 	fmt.Printf("\n")
 	fmt.Printf("Nodes in Mesh:\n")
 
@@ -149,7 +166,8 @@ func printNodes(nodes []*gomeshproto.FromRadio_NodeInfo) {
 	fmt.Printf("%-15s| ", "Battery")
 	fmt.Printf("%-15s| ", "Altitude")
 	fmt.Printf("%-15s| ", "Latitude")
-	fmt.Printf("%-15s", "Longitude      |\n")
+	fmt.Printf("%-15s| ", "Longitude")
+	fmt.Printf("%-27s|\n", "Key")
 	printSingleDivider()
 	for _, node := range nodes {
 		if node.NodeInfo != nil {
@@ -167,12 +185,20 @@ func printNodes(nodes []*gomeshproto.FromRadio_NodeInfo) {
 			if node.NodeInfo.Position != nil {
 				fmt.Printf("%-15s| ", fmt.Sprint(node.NodeInfo.Position.Altitude))
 				fmt.Printf("%-15s| ", fmt.Sprint(node.NodeInfo.Position.LatitudeI))
-				fmt.Printf("%-15s", fmt.Sprint(node.NodeInfo.Position.LongitudeI))
+				fmt.Printf("%-15s| ", fmt.Sprint(node.NodeInfo.Position.LongitudeI))
 			} else {
 				fmt.Printf("%-15s| ", "N/A")
 				fmt.Printf("%-15s| ", "N/A")
 				fmt.Printf("%-15s| ", "N/A")
 			}
+			key := "N/A"
+			if publicKey := nodePublicKey(node.NodeInfo.User); publicKey != nil {
+				key = keyFingerprint(publicKey)
+			}
+			if keys[node.NodeInfo.Num] == keyChanged {
+				key += " CHANGED"
+			}
+			fmt.Printf("%-27s", key)
 			fmt.Printf("%s", "|\n")
 		}
 	}
@@ -237,6 +263,7 @@ func printRadioInfo(info []*gomeshproto.FromRadio) {
 	nodes := make([]*gomeshproto.FromRadio_NodeInfo, 0)
 	channels := make([]*gomeshproto.Channel, 0)
 	positionPacket := &gomeshproto.FromRadio{}
+	var loraConfig *gomeshproto.Config_LoRaConfig
 
 	for _, packet := range info {
 		if nodeInfo, ok := packet.GetPayloadVariant().(*gomeshproto.FromRadio_NodeInfo); ok {
@@ -249,6 +276,9 @@ func printRadioInfo(info []*gomeshproto.FromRadio) {
 			if gpsConfig := config.GetPosition(); gpsConfig != nil {
 				positionPacket = packet
 			}
+			if lora := config.GetLora(); lora != nil {
+				loraConfig = lora
+			}
 			if deviceInfo := config.GetDevice(); deviceInfo != nil {
 				fmt.Printf("%s", "\nDevice Settings\n")
 				v := reflect.ValueOf(*deviceInfo)
@@ -282,8 +312,8 @@ func printRadioInfo(info []*gomeshproto.FromRadio) {
 	}
 
 	displayPositionInfo(positionPacket)
-	printNodes(nodes)
-	printChannels(channels)
+	printNodes(nodes, nil)
+	printChannels(channels, loraConfig)
 
 }
 
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Public key encryption fields that are newer than the protobufs included with gomesh
+const (
+	userPublicKey      = 8  // User.public_key
+	packetPublicKey    = 16 // MeshPacket.public_key
+	packetPkiEncrypted = 17 // MeshPacket.pki_encrypted
+	configSecurity     = 8  // Config.security
+	securityPublicKey  = 1  // SecurityConfig.public_key
+	securityPrivateKey = 2  // SecurityConfig.private_key
+	securityAdminKey   = 3  // SecurityConfig.admin_key
+)
+
+// keyLen is the length of a Curve25519 key
+const keyLen = 32
+
+// maxAdminKeys is the most admin keys the firmware stores
+const maxAdminKeys = 3
+
+// Routing errors for public key encryption that are newer than the protobufs included with gomesh
+var pkiRoutingErrors = map[gomeshproto.Routing_Error]string{
+	34: "PKI_FAILED",
+	35: "PKI_UNKNOWN_PUBKEY",
+	36: "ADMIN_BAD_SESSION_KEY",
+	37: "ADMIN_PUBLIC_KEY_UNAUTHORIZED",
+}
+
+// keyStatus is how a node's public key compares with the key first seen for it
+type keyStatus int
+
+const (
+	keyNone keyStatus = iota
+	keyNew
+	keyTrusted
+	keyChanged
+)
+
+// knownKey is a node's public key as it was first seen, which later keys are checked against
+type knownKey struct {
+	Key       string    `json:"key"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// securityConfig is the key pair and admin keys set on the radio. Other security settings are kept as
+// they were read so they're sent back unchanged
+type securityConfig struct {
+	publicKey  []byte
+	privateKey []byte
+	adminKeys  [][]byte
+	other      []byte
+}
+
+func showKeys(c *cli.Context) error {
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	security, err := getSecurityConfig(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("%-15s%s\n", "Public key", encodeKey(security.publicKey))
+	fmt.Printf("%-15s%s\n", "Fingerprint", keyFingerprint(security.publicKey))
+	if c.Bool("private") {
+		fmt.Printf("%-15s%s\n", "Private key", encodeKey(security.privateKey))
+	} else {
+		fmt.Printf("%-15s%s\n", "Private key", "hidden, use --private to show it")
+	}
+
+	fmt.Printf("\nAdmin keys:\n")
+	if len(security.adminKeys) == 0 {
+		fmt.Println("None")
+	}
+	for _, key := range security.adminKeys {
+		fmt.Printf("%s  %s\n", encodeKey(key), keyFingerprint(key))
+	}
+
+	return nil
+}
+
+func generateKeys(c *cli.Context) error {
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	security, err := getSecurityConfig(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	// The firmware generates a new key pair when it starts without a private key
+	security.publicKey = nil
+	security.privateKey = nil
+	if err := setSecurityConfig(radio, security); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Println("Private key cleared, the radio will generate a new key pair when it restarts")
+	fmt.Println("Other nodes will see the key change, so let their owners know to expect it")
+	return nil
+}
+
+func importPrivateKey(c *cli.Context) error {
+
+	if c.NArg() != 1 {
+		return cli.Exit("A base64 private key is required", 0)
+	}
+	key, err := decodeKey(c.Args().First())
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	security, err := getSecurityConfig(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	// The firmware works out the public key from the private key
+	security.publicKey = nil
+	security.privateKey = key
+	if err := setSecurityConfig(radio, security); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Println("Private key set")
+	return nil
+}
+
+func addAdminKey(c *cli.Context) error {
+
+	if c.NArg() != 1 {
+		return cli.Exit("A base64 public key is required", 0)
+	}
+	key, err := decodeKey(c.Args().First())
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	security, err := getSecurityConfig(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	for _, adminKey := range security.adminKeys {
+		if bytes.Equal(adminKey, key) {
+			return cli.Exit("That admin key is already set", 0)
+		}
+	}
+	if len(security.adminKeys) >= maxAdminKeys {
+		return cli.Exit(fmt.Sprintf("The radio already has %d admin keys, remove one first", maxAdminKeys), 0)
+	}
+
+	security.adminKeys = append(security.adminKeys, key)
+	if err := setSecurityConfig(radio, security); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("Added admin key %s\n", keyFingerprint(key))
+	return nil
+}
+
+func removeAdminKey(c *cli.Context) error {
+
+	if c.NArg() != 1 {
+		return cli.Exit("A base64 public key is required", 0)
+	}
+	key, err := decodeKey(c.Args().First())
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	security, err := getSecurityConfig(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	adminKeys := make([][]byte, 0, len(security.adminKeys))
+	for _, adminKey := range security.adminKeys {
+		if !bytes.Equal(adminKey, key) {
+			adminKeys = append(adminKeys, adminKey)
+		}
+	}
+	if len(adminKeys) == len(security.adminKeys) {
+		return cli.Exit("That admin key isn't set", 0)
+	}
+
+	security.adminKeys = adminKeys
+	if err := setSecurityConfig(radio, security); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("Removed admin key %s\n", keyFingerprint(key))
+	return nil
+}
+
+func trustKey(c *cli.Context) error {
+
+	if c.NArg() != 1 {
+		return cli.Exit("A node is required", 0)
+	}
+	num, err := parseNodeID(c.Args().First())
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	node, err := findNode(radio, num)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	if node == nil {
+		return cli.Exit(fmt.Sprintf("%s isn't in the radio's node database", nodeID(num)), 0)
+	}
+	key := nodePublicKey(node.GetUser())
+	if key == nil {
+		return cli.Exit(fmt.Sprintf("No public key known for %s", nodeID(num)), 0)
+	}
+
+	path, err := dataPath("known-keys.json")
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	known, err := loadKnownKeys(path)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	known[nodeID(num)] = knownKey{Key: encodeKey(key), FirstSeen: time.Now()}
+	if err := saveKnownKeys(path, known); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("Trusted key %s for %s\n", keyFingerprint(key), nodeID(num))
+	return nil
+}
+
+// getSecurityConfig reads the key pair and admin keys set on the radio
+func getSecurityConfig(r gomesh.Radio) (*securityConfig, error) {
+	responses, err := r.GetRadioInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, response := range responses {
+		config := response.GetConfig()
+		if config == nil {
+			continue
+		}
+		if fields := unknownBytes(config, configSecurity); len(fields) > 0 {
+			return parseSecurityConfig(fields[len(fields)-1])
+		}
+	}
+
+	return nil, errors.New("the radio didn't send a security config, public key encryption needs firmware 2.5 or newer")
+}
+
+// setSecurityConfig sets the key pair and admin keys on the radio, which restarts it
+func setSecurityConfig(r gomesh.Radio, security *securityConfig) error {
+	nodeNum, err := getNodeNum(r)
+	if err != nil {
+		return err
+	}
+
+	config := &gomeshproto.Config{}
+	config.ProtoReflect().SetUnknown(appendBytesField(nil, configSecurity, security.encode()))
+
+	return sendAdminMessage(r, nodeNum, &gomeshproto.AdminMessage{
+		PayloadVariant: &gomeshproto.AdminMessage_SetConfig{
+			SetConfig: config,
+		},
+	})
+}
+
+// parseSecurityConfig decodes a SecurityConfig message
+func parseSecurityConfig(data []byte) (*securityConfig, error) {
+	security := &securityConfig{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, errors.New("invalid security config")
+		}
+		size := protowire.ConsumeFieldValue(num, typ, data[n:])
+		if size < 0 {
+			return nil, errors.New("invalid security config")
+		}
+
+		if typ == protowire.BytesType && (num == securityPublicKey || num == securityPrivateKey || num == securityAdminKey) {
+			value, _ := protowire.ConsumeBytes(data[n:])
+			switch num {
+			case securityPublicKey:
+				security.publicKey = value
+			case securityPrivateKey:
+				security.privateKey = value
+			case securityAdminKey:
+				security.adminKeys = append(security.adminKeys, value)
+			}
+		} else {
+			security.other = append(security.other, data[:n+size]...)
+		}
+		data = data[n+size:]
+	}
+
+	return security, nil
+}
+
+// encode encodes the security config as a SecurityConfig message
+func (s *securityConfig) encode() []byte {
+	out := make([]byte, 0)
+	if len(s.publicKey) > 0 {
+		out = appendBytesField(out, securityPublicKey, s.publicKey)
+	}
+	if len(s.privateKey) > 0 {
+		out = appendBytesField(out, securityPrivateKey, s.privateKey)
+	}
+	for _, key := range s.adminKeys {
+		out = appendBytesField(out, securityAdminKey, key)
+	}
+
+	return append(out, s.other...)
+}
+
+// appendBytesField appends a length delimited field to an encoded message
+func appendBytesField(out []byte, number protowire.Number, value []byte) []byte {
+	out = protowire.AppendTag(out, number, protowire.BytesType)
+	return protowire.AppendBytes(out, value)
+}
+
+// unknownBytes finds the values of a length delimited field that isn't in the protobufs included with
+// gomesh in the unknown fields of a message
+func unknownBytes(m proto.Message, number protowire.Number) [][]byte {
+	values := make([][]byte, 0)
+	unknown := m.ProtoReflect().GetUnknown()
+	for len(unknown) > 0 {
+		num, typ, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return values
+		}
+		unknown = unknown[n:]
+
+		if num == number && typ == protowire.BytesType {
+			value, n := protowire.ConsumeBytes(unknown)
+			if n < 0 {
+				return values
+			}
+			values = append(values, value)
+			unknown = unknown[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, unknown)
+		if n < 0 {
+			return values
+		}
+		unknown = unknown[n:]
+	}
+
+	return values
+}
+
+// nodePublicKey returns the public key a node advertises in its user info, or nil if it doesn't have one
+func nodePublicKey(user *gomeshproto.User) []byte {
+	if user == nil {
+		return nil
+	}
+
+	keys := unknownBytes(user, userPublicKey)
+	if len(keys) == 0 || len(keys[len(keys)-1]) != keyLen {
+		return nil
+	}
+	return keys[len(keys)-1]
+}
+
+// setPkiEncrypted asks the radio to encrypt a packet with the destination's public key rather than the
+// channel key. The radio refuses to send it if the key doesn't match the one in its node database
+func setPkiEncrypted(packet *gomeshproto.MeshPacket, key []byte) {
+	fields := appendBytesField(packet.ProtoReflect().GetUnknown(), packetPublicKey, key)
+	fields = protowire.AppendTag(fields, packetPkiEncrypted, protowire.VarintType)
+	fields = protowire.AppendVarint(fields, protowire.EncodeBool(true))
+	packet.ProtoReflect().SetUnknown(fields)
+}
+
+// keyFingerprint returns a short form of a key for comparing by eye
+func keyFingerprint(key []byte) string {
+	if len(key) == 0 {
+		return "none"
+	}
+
+	sum := sha256.Sum256(key)
+	digits := hex.EncodeToString(sum[:8])
+	return strings.Join([]string{digits[0:4], digits[4:8], digits[8:12], digits[12:16]}, ":")
+}
+
+// encodeKey formats a key in base64 the way the meshtastic apps display keys
+func encodeKey(key []byte) string {
+	if len(key) == 0 {
+		return "none"
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// decodeKey parses a base64 key
+func decodeKey(value string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+	if err != nil || len(key) != keyLen {
+		return nil, fmt.Errorf("invalid key %q, expected %d bytes in base64", value, keyLen)
+	}
+	return key, nil
+}
+
+// findNode returns a node from the radio's node database, or nil if it isn't there
+func findNode(r gomesh.Radio, num uint32) (*gomeshproto.NodeInfo, error) {
+	responses, err := r.GetRadioInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, response := range responses {
+		if node := response.GetNodeInfo(); node != nil && node.Num == num {
+			return node, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// errKeyStore is returned when the keys first seen for each node can't be read
+var errKeyStore = errors.New("couldn't read the known keys")
+
+// checkNodeKeys compares the public keys of nodes with the keys first seen for them, remembering keys
+// seen for the first time. A warning is printed for each key that has changed
+func checkNodeKeys(nodes []*gomeshproto.NodeInfo) (map[uint32]keyStatus, error) {
+	path, err := dataPath("known-keys.json")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errKeyStore, err)
+	}
+	known, err := loadKnownKeys(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errKeyStore, err)
+	}
+
+	statuses := make(map[uint32]keyStatus)
+	added := false
+	for _, node := range nodes {
+		key := nodePublicKey(node.GetUser())
+		if key == nil {
+			statuses[node.Num] = keyNone
+			continue
+		}
+
+		pinned, ok := known[nodeID(node.Num)]
+		switch {
+		case !ok:
+			known[nodeID(node.Num)] = knownKey{Key: encodeKey(key), FirstSeen: time.Now()}
+			statuses[node.Num] = keyNew
+			added = true
+		case pinned.Key == encodeKey(key):
+			statuses[node.Num] = keyTrusted
+		default:
+			statuses[node.Num] = keyChanged
+			pinnedKey, _ := base64.StdEncoding.DecodeString(pinned.Key)
+			fmt.Fprintf(os.Stderr, "WARNING: the public key for %s has changed from %s (first seen %s) to %s. "+
+				"Check with the node's owner, then run `keys trust %s` to accept it\n",
+				nodeID(node.Num), keyFingerprint(pinnedKey), pinned.FirstSeen.Format("2006-01-02"), keyFingerprint(key), nodeID(node.Num))
+		}
+	}
+
+	// The keys checked against are still right if they can't be saved, so only warn
+	if added {
+		if err := saveKnownKeys(path, known); err != nil {
+			fmt.Fprintf(os.Stderr, "Couldn't save the keys seen for new nodes: %v\n", err)
+		}
+	}
+
+	return statuses, nil
+}
+
+// loadKnownKeys reads the public keys first seen for each node
+func loadKnownKeys(path string) (map[string]knownKey, error) {
+	known := make(map[string]knownKey)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &known); err != nil {
+		return nil, fmt.Errorf("invalid known keys file %s: %v", path, err)
+	}
+
+	return known, nil
+}
+
+// saveKnownKeys writes the public keys first seen for each node
+func saveKnownKeys(path string, known map[string]knownKey) error {
+	data, err := json.MarshalIndent(known, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// destinationKey returns the public key to encrypt a direct message to a node with, or nil if no key is
+// known for it. An error is returned if the node's key has changed since it was first seen, or one
+// wrapping errKeyStore if that can't be checked
+func destinationKey(r gomesh.Radio, to uint32) ([]byte, error) {
+	node, err := findNode(r, to)
+	if err != nil || node == nil {
+		return nil, err
+	}
+
+	statuses, err := checkNodeKeys([]*gomeshproto.NodeInfo{node})
+	if err != nil {
+		return nil, err
+	}
+	if statuses[to] == keyChanged {
+		return nil, fmt.Errorf("the public key for %s has changed since it was first seen, run `keys trust %s` if it's expected or send with --pki off", nodeID(to), nodeID(to))
+	}
+
+	return nodePublicKey(node.GetUser()), nil
+}
+
+// routingErrorName returns the name of a routing error, including errors newer than the protobufs
+// included with gomesh
+func routingErrorName(reason gomeshproto.Routing_Error) string {
+	if name, ok := pkiRoutingErrors[reason]; ok {
+		return name
+	}
+	return reason.String()
+}
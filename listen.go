@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// packetEvent is a received packet in the form shown by listen --json
+type packetEvent struct {
+	ID      uint32    `json:"id"`
+	From    string    `json:"from"`
+	To      string    `json:"to"`
+	Channel uint32    `json:"channel"`
+	Port    string    `json:"port"`
+	Snr     float64   `json:"snr"`
+	Rssi    int32     `json:"rssi"`
+	Hops    *uint32   `json:"hops,omitempty"`
+	ReplyID uint32    `json:"reply_id,omitempty"`
+	Emoji   bool      `json:"emoji,omitempty"`
+	Text    string    `json:"text,omitempty"`
+	Payload []byte    `json:"payload"`
+	Time    time.Time `json:"time"`
+}
+
+func listenMesh(c *cli.Context) error {
+
+	filter, err := filterFromFlags(c)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	hooks, err := newHookRunner(c, radio, filter)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	if !c.Bool("json") && !c.Bool("quiet") {
+		printPacketHeader()
+	}
+
+	err = listenPackets(radio, time.Time{}, func(packet *gomeshproto.MeshPacket) error {
+		// Rules have their own filters, so they see every packet
+		hooks.handle(radio, packet)
+
+		if !filter.match(packet) || c.Bool("quiet") {
+			return nil
+		}
+
+		event := newPacketEvent(packet)
+		if c.Bool("json") {
+			out, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			return nil
+		}
+
+		printPacketEvent(event)
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+// newPacketEvent converts a received packet into the form shown by listen
+func newPacketEvent(packet *gomeshproto.MeshPacket) packetEvent {
+	decoded := packet.GetDecoded()
+	event := packetEvent{
+		ID:      packet.Id,
+		From:    nodeID(packet.From),
+		To:      nodeID(packet.To),
+		Channel: packet.Channel,
+		Port:    decoded.GetPortnum().String(),
+		Snr:     widenFloat(packet.RxSnr),
+		Rssi:    packet.RxRssi,
+		ReplyID: decoded.GetReplyId(),
+		Emoji:   decoded.GetEmoji() != 0,
+		Payload: decoded.GetPayload(),
+		Time:    time.Now(),
+	}
+	if packet.To == broadcastNum {
+		event.To = "all"
+	}
+	if packet.HopStart > 0 {
+		hops := packet.HopStart - packet.HopLimit
+		event.Hops = &hops
+	}
+	if packet.RxTime > 0 {
+		event.Time = time.Unix(int64(packet.RxTime), 0)
+	}
+	if decoded.GetPortnum() == gomeshproto.PortNum_TEXT_MESSAGE_APP {
+		event.Text = string(decoded.GetPayload())
+	}
+
+	return event
+}
+
+func printPacketHeader() {
+	fmt.Printf("\n")
+	fmt.Printf("Received Packets:\n")
+	printDoubleDivider()
+	fmt.Printf("| %-9s| ", "Time")
+	fmt.Printf("%-12s| ", "ID")
+	fmt.Printf("%-10s| ", "From")
+	fmt.Printf("%-10s| ", "To")
+	fmt.Printf("%-20s| ", "Port Num")
+	fmt.Printf("%-8s| ", "Channel")
+	fmt.Printf("%-7s| ", "SNR")
+	fmt.Printf("%-5s| ", "Hops")
+	fmt.Printf("%-53s|\n", "Payload")
+	printSingleDivider()
+}
+
+func printPacketEvent(event packetEvent) {
+	hops := "?"
+	if event.Hops != nil {
+		hops = fmt.Sprint(*event.Hops)
+	}
+
+	// Text is shown as it is, other payloads as their size since they're binary
+	payload := fmt.Sprintf("%d bytes", len(event.Payload))
+	if event.Port == gomeshproto.PortNum_TEXT_MESSAGE_APP.String() {
+		payload = fmt.Sprintf("%q", event.Text)
+	}
+
+	fmt.Printf("| %-9s| ", event.Time.Format("15:04:05"))
+	fmt.Printf("%-12d| ", event.ID)
+	fmt.Printf("%-10s| ", event.From)
+	fmt.Printf("%-10s| ", event.To)
+	fmt.Printf("%-20s| ", event.Port)
+	fmt.Printf("%-8d| ", event.Channel)
+	fmt.Printf("%-7s| ", fmt.Sprint(event.Snr))
+	fmt.Printf("%-5s| ", hops)
+	fmt.Printf("%-53s|\n", payload)
+}
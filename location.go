@@ -1,13 +1,148 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
 	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// Admin message fields that are newer than the protobufs included with gomesh
+const (
+	adminSetFixedPosition    = 41
+	adminRemoveFixedPosition = 42
 )
 
+// broadcastNum is the node number used to send a packet to every node on the mesh
+const broadcastNum = 0xffffffff
+
 func setLocation(c *cli.Context) error {
 	radio := getRadio(c)
 	defer radio.Close()
 
-	alt := int32(c.Int("alt"))
-	return radio.SetLocation(int32(c.Int64("lat")), int32(c.Int64("long")), alt)
+	if c.Bool("remove-fixed") {
+		nodeNum, err := getNodeNum(radio)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+
+		payload := protowire.AppendTag(nil, adminRemoveFixedPosition, protowire.VarintType)
+		payload = protowire.AppendVarint(payload, protowire.EncodeBool(true))
+		err = sendAdminPayload(radio, nodeNum, payload)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+
+		fmt.Println("Fixed position removed")
+		return nil
+	}
+
+	position, err := positionFromFlags(c)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	if c.Bool("fixed") {
+		nodeNum, err := getNodeNum(radio)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+
+		out, err := proto.Marshal(position)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+
+		payload := protowire.AppendTag(nil, adminSetFixedPosition, protowire.BytesType)
+		payload = protowire.AppendBytes(payload, out)
+		err = sendAdminPayload(radio, nodeNum, payload)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	} else {
+		err = radio.SetLocation(position.LatitudeI, position.LongitudeI, position.Altitude)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	if c.Bool("broadcast") {
+		err = broadcastPosition(radio, position, uint32(c.Uint("precision")), uint32(c.Int64("channel")))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	fmt.Printf("Location set to %.7f, %.7f\n", float64(position.LatitudeI)/1e7, float64(position.LongitudeI)/1e7)
+	return nil
+}
+
+// positionFromFlags builds a position from either the --grid flag or the --lat and --long flags
+func positionFromFlags(c *cli.Context) (*gomeshproto.Position, error) {
+	var lat, lon float64
+	var err error
+
+	if c.IsSet("grid") {
+		lat, lon, err = parseGridReference(c.String("grid"))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if !c.IsSet("lat") || !c.IsSet("long") {
+			return nil, errors.New("--lat and --long or --grid are required")
+		}
+		lat, err = parseCoordinate(c.String("lat"), true)
+		if err != nil {
+			return nil, fmt.Errorf("invalid latitude: %v", err)
+		}
+		lon, err = parseCoordinate(c.String("long"), false)
+		if err != nil {
+			return nil, fmt.Errorf("invalid longitude: %v", err)
+		}
+	}
+
+	return &gomeshproto.Position{
+		LatitudeI:      degreesToInt(lat),
+		LongitudeI:     degreesToInt(lon),
+		Altitude:       int32(c.Int("alt")),
+		Time:           uint32(time.Now().Unix()),
+		LocationSource: gomeshproto.Position_LOC_MANUAL,
+	}, nil
+}
+
+// broadcastPosition sends the position to the mesh on POSITION_APP. The coordinates are truncated to the
+// requested number of precision bits the same way the firmware does before they're sent
+func broadcastPosition(r gomesh.Radio, position *gomeshproto.Position, precision uint32, channel uint32) error {
+	if precision == 0 || precision > 32 {
+		return errors.New("precision must be between 1 and 32 bits")
+	}
+
+	broadcast := proto.Clone(position).(*gomeshproto.Position)
+	if precision < 32 {
+		mask := uint32(0xffffffff) << (32 - precision)
+		broadcast.LatitudeI = int32(uint32(broadcast.LatitudeI)&mask) + int32(1)<<(31-precision)
+		broadcast.LongitudeI = int32(uint32(broadcast.LongitudeI)&mask) + int32(1)<<(31-precision)
+	}
+	broadcast.PrecisionBits = precision
+
+	out, err := proto.Marshal(broadcast)
+	if err != nil {
+		return err
+	}
+
+	return sendPacket(r, &gomeshproto.MeshPacket{
+		To:      broadcastNum,
+		Channel: channel,
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Payload: out,
+				Portnum: gomeshproto.PortNum_POSITION_APP,
+			},
+		},
+	})
 }
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// partAckTimeout is how long to wait for each part of a long message to be acknowledged before sending
+// the next one
+const partAckTimeout = time.Minute
+
+// partialMessageAge is how long the parts of a long message are held waiting for the rest to arrive
+// before they're shown on their own
+const partialMessageAge = 5 * time.Minute
+
+// lonePartAge is how long the last part of a long message is held when it arrives before any of the
+// others. It's shorter than partialMessageAge since a message that only looks numbered, such as "2/2 done",
+// is held as well
+const lonePartAge = 30 * time.Second
+
+// messagePartPattern matches the "1/3 " numbering at the start of each part of a long message. Numbering
+// in brackets or parentheses from other clients is also accepted
+var messagePartPattern = regexp.MustCompile(`^[\[(]?(\d{1,3})/(\d{1,3})[\])]?\s`)
+
+// messagePartKey identifies the long message a part belongs to
+type messagePartKey struct {
+	from    uint32
+	to      uint32
+	channel uint32
+	total   int
+}
+
+// partialMessage is a long message waiting for the rest of its parts
+type partialMessage struct {
+	parts    []*gomeshproto.MeshPacket
+	texts    []string
+	received int
+	started  time.Time
+}
+
+// messageAssembler stitches the parts of long messages back together
+type messageAssembler struct {
+	partials map[messagePartKey]*partialMessage
+}
+
+func newMessageAssembler() *messageAssembler {
+	return &messageAssembler{partials: make(map[messagePartKey]*partialMessage)}
+}
+
+// maxTextDataLen is the largest encoded Data message the radio accepts for text, which is a text message
+// with the longest payload the firmware allows
+var maxTextDataLen = proto.Size(&gomeshproto.Data{
+	Portnum: gomeshproto.PortNum_TEXT_MESSAGE_APP,
+	Payload: make([]byte, gomeshproto.Constants_DATA_PAYLOAD_LEN),
+})
+
+// pkiOverhead is how much longer public key encryption makes a packet than channel encryption, for the
+// authentication tag and extra nonce
+const pkiOverhead = 12
+
+// textBudget returns how many bytes of UTF-8 text fit in a text message. Setting a reply ID or emoji
+// flag adds fields to the encoded message, and public key encryption adds its overhead, which leaves less
+// room for the text
+func textBudget(replyID uint32, emoji uint32, pki bool) int {
+	limit := maxTextDataLen
+	if pki {
+		limit -= pkiOverhead
+	}
+
+	data := &gomeshproto.Data{Portnum: gomeshproto.PortNum_TEXT_MESSAGE_APP, ReplyId: replyID, Emoji: emoji}
+	for n := int(gomeshproto.Constants_DATA_PAYLOAD_LEN); n > 0; n-- {
+		data.Payload = make([]byte, n)
+		if proto.Size(data) <= limit {
+			return n
+		}
+	}
+
+	return 0
+}
+
+// truncateText cuts text to at most budget bytes without splitting a UTF-8 character
+func truncateText(text string, budget int) string {
+	if len(text) <= budget {
+		return text
+	}
+
+	end := budget
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end]
+}
+
+// textOverage describes how far text is over the budget for a single message
+func textOverage(text string, budget int) string {
+	return fmt.Sprintf("message is %d bytes (%d characters), %d bytes over the %d byte limit",
+		len(text), utf8.RuneCountInString(text), len(text)-budget, budget)
+}
+
+// splitText splits text into numbered parts that each fit in budget bytes, breaking between words where
+// possible and never inside a UTF-8 character. Text that already fits is returned as it is
+func splitText(text string, budget int) []string {
+	if len(text) <= budget {
+		return []string{text}
+	}
+
+	// The numbering gets longer as the number of parts grows, so split again until it fits
+	total := 1
+	for {
+		prefixLen := len(fmt.Sprintf("%d/%d ", total, total))
+		if prefixLen >= budget {
+			return nil
+		}
+
+		bodies := splitWords(text, budget-prefixLen)
+		if len(bodies) <= total {
+			parts := make([]string, len(bodies))
+			for i, body := range bodies {
+				parts[i] = fmt.Sprintf("%d/%d %s", i+1, len(bodies), body)
+			}
+			return parts
+		}
+		total = len(bodies)
+	}
+}
+
+// splitWords splits text into pieces of at most size bytes, after the last space in each piece if it's in
+// the second half. The space stays at the end of the piece it follows, so joining the pieces gives back the
+// text exactly, even when a word has to be split. Keeping every piece but the last at least half full is
+// what lets receivers tell parts of a long message from messages that only look numbered
+func splitWords(text string, size int) []string {
+	pieces := make([]string, 0)
+	for len(text) > size {
+		end := size
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		if space := strings.LastIndexAny(text[:end], " \n"); space >= end/2 {
+			end = space + 1
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+
+	return append(pieces, text)
+}
+
+// parseMessagePart returns the part number, number of parts and text of one part of a long message
+func parseMessagePart(text string) (int, int, string, bool) {
+	match := messagePartPattern.FindStringSubmatch(text)
+	if match == nil {
+		return 0, 0, "", false
+	}
+
+	index, _ := strconv.Atoi(match[1])
+	total, _ := strconv.Atoi(match[2])
+	if total < 2 || index < 1 || index > total {
+		return 0, 0, "", false
+	}
+
+	return index, total, text[len(match[0]):], true
+}
+
+// looksSplit reports whether a message numbered like a part of a long message really is one, so that
+// messages that just start with something like "1/2 cup" are shown straight away. Splitting fills every
+// part but the last close to the byte limit, so the last part can be any length
+func looksSplit(packet *gomeshproto.MeshPacket, index int, total int) bool {
+	if index == total {
+		return true
+	}
+
+	// Parts are split at a space in their second half, or in the middle of a word if there isn't one
+	budget := textBudget(packet.GetDecoded().GetReplyId(), 0, true)
+	return len(packet.GetDecoded().GetPayload()) >= budget/2
+}
+
+// add adds a received text message packet and returns the messages that are ready to show. That's the
+// packet itself unless it's part of a long message, in which case a packet holding the whole message is
+// returned once every part has arrived
+func (a *messageAssembler) add(packet *gomeshproto.MeshPacket) []*gomeshproto.MeshPacket {
+	index, total, text, ok := parseMessagePart(string(packet.GetDecoded().GetPayload()))
+	if !ok {
+		return []*gomeshproto.MeshPacket{packet}
+	}
+
+	key := messagePartKey{from: packet.From, to: packet.To, channel: packet.Channel, total: total}
+	partial, ok := a.partials[key]
+	if !looksSplit(packet, index, total) {
+		return []*gomeshproto.MeshPacket{packet}
+	}
+
+	ready := make([]*gomeshproto.MeshPacket, 0)
+	if ok && partial.parts[index-1] != nil {
+		// A part we already have means the sender has started another long message
+		ready = append(ready, a.flush(key)...)
+		ok = false
+	}
+	if !ok {
+		partial = &partialMessage{
+			parts:   make([]*gomeshproto.MeshPacket, total),
+			texts:   make([]string, total),
+			started: time.Now(),
+		}
+		a.partials[key] = partial
+	}
+
+	partial.parts[index-1] = packet
+	partial.texts[index-1] = text
+	partial.received++
+	if partial.received < total {
+		return ready
+	}
+
+	delete(a.partials, key)
+	whole := proto.Clone(partial.parts[0]).(*gomeshproto.MeshPacket)
+	whole.GetDecoded().Payload = []byte(strings.Join(partial.texts, ""))
+	whole.RxSnr = packet.RxSnr
+	whole.RxRssi = packet.RxRssi
+
+	return append(ready, whole)
+}
+
+// expire returns the parts of long messages that have waited too long for the rest to arrive, so they
+// can be shown on their own
+func (a *messageAssembler) expire() []*gomeshproto.MeshPacket {
+	expired := make([]*gomeshproto.MeshPacket, 0)
+	for key, partial := range a.partials {
+		age := partialMessageAge
+		if partial.received == 1 && partial.parts[len(partial.parts)-1] != nil {
+			age = lonePartAge
+		}
+		if time.Since(partial.started) > age {
+			expired = append(expired, a.flush(key)...)
+		}
+	}
+
+	return expired
+}
+
+// flush removes a long message that's waiting for parts and returns the parts that did arrive
+func (a *messageAssembler) flush(key messagePartKey) []*gomeshproto.MeshPacket {
+	parts := make([]*gomeshproto.MeshPacket, 0)
+	for _, part := range a.partials[key].parts {
+		if part != nil {
+			parts = append(parts, part)
+		}
+	}
+	delete(a.partials, key)
+
+	return parts
+}
+
+// sendLongText sends text as a single message if it fits, or splits it into numbered parts. Each part is
+// acknowledged before the next is sent so the parts don't flood the radio's queue or arrive out of order.
+// Every part of a reply is sent as a reply, every part is encrypted with publicKey if one is given, and
+// the packet IDs of the messages sent are returned
+func sendLongText(r gomesh.Radio, text string, to uint32, channel uint32, replyID uint32, publicKey []byte) ([]uint32, error) {
+	parts := splitText(text, textBudget(replyID, 0, publicKey != nil))
+	ids := make([]uint32, 0, len(parts))
+	for i, part := range parts {
+		id, err := sendTextMessage(r, part, to, channel, replyID, 0, publicKey)
+		if err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+
+		if i < len(parts)-1 {
+			if err := waitForAck(r, id, partAckTimeout); err != nil {
+				return ids, fmt.Errorf("part %d of %d: %v", i+1, len(parts), err)
+			}
+		}
+	}
+
+	return ids, nil
+}
+
+// waitForAck waits for the radio to report that a packet was acknowledged
+func waitForAck(r gomesh.Radio, id uint32, timeout time.Duration) error {
+	acked := false
+	err := listenPackets(r, time.Now().Add(timeout), func(packet *gomeshproto.MeshPacket) error {
+		if packet.GetDecoded().GetPortnum() != gomeshproto.PortNum_ROUTING_APP || packet.GetDecoded().GetRequestId() != id {
+			return nil
+		}
+		return routingResult(packet, &acked)
+	})
+	if err != nil {
+		return err
+	}
+	if !acked {
+		return fmt.Errorf("no acknowledgement after %s", timeout)
+	}
+
+	return nil
+}
+
+// routingResult checks a routing packet sent in reply to one of ours. acked is set and errStopListening
+// returned if it was delivered, otherwise the routing error is returned
+func routingResult(packet *gomeshproto.MeshPacket, acked *bool) error {
+	routing := gomeshproto.Routing{}
+	if err := unmarshalPayload(packet, &routing); err != nil {
+		return err
+	}
+	if routing.GetErrorReason() != gomeshproto.Routing_NONE {
+		return fmt.Errorf("not delivered: %s", routingErrorName(routing.GetErrorReason()))
+	}
+
+	*acked = true
+	return errStopListening
+}
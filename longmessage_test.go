@@ -0,0 +1,155 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+)
+
+// textPacket is a text message from node 1 to everyone
+func textPacket(text string) *gomeshproto.MeshPacket {
+	return &gomeshproto.MeshPacket{
+		From: 1,
+		To:   broadcastNum,
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{Portnum: gomeshproto.PortNum_TEXT_MESSAGE_APP, Payload: []byte(text)},
+		},
+	}
+}
+
+// reassemble passes parts through a message assembler in the given order and returns what it shows
+func reassemble(t *testing.T, parts []string, order []int) []string {
+	assembler := newMessageAssembler()
+	shown := make([]string, 0)
+	for _, i := range order {
+		for _, packet := range assembler.add(textPacket(parts[i])) {
+			shown = append(shown, string(packet.GetDecoded().GetPayload()))
+		}
+	}
+	if len(assembler.partials) != 0 {
+		t.Errorf("%d long messages still waiting for parts", len(assembler.partials))
+	}
+
+	return shown
+}
+
+func TestSplitTextRoundTrip(t *testing.T) {
+	budget := textBudget(0, 0, false)
+	tests := []struct {
+		name string
+		text string
+	}{
+		{"words", strings.Repeat("the quick brown fox ", 40)},
+		{"double spaces", strings.Repeat("two  spaces  ", 40)},
+		{"newlines", strings.Repeat("line one\nline two\n", 30)},
+		{"trailing space", strings.Repeat("word ", 60) + " "},
+		{"long word", strings.Repeat("a", 3*budget)},
+		{"long word between words", "start " + strings.Repeat("b", 2*budget) + " end"},
+		{"multibyte", strings.Repeat("héllo wörld ", 40)},
+		{"multibyte long word", strings.Repeat("日本語", 150)},
+		{"emoji", strings.Repeat("🙂", 200)},
+	}
+
+	for _, test := range tests {
+		parts := splitText(test.text, budget)
+		if len(parts) < 2 {
+			t.Errorf("%s: split into %d parts", test.name, len(parts))
+			continue
+		}
+
+		for i, part := range parts {
+			if len(part) > budget {
+				t.Errorf("%s: part %d is %d bytes, over the %d byte budget", test.name, i+1, len(part), budget)
+			}
+			if !utf8.ValidString(part) {
+				t.Errorf("%s: part %d splits a UTF-8 character", test.name, i+1)
+			}
+		}
+
+		order := make([]int, len(parts))
+		for i := range order {
+			order[i] = i
+		}
+		shown := reassemble(t, parts, order)
+		if len(shown) != 1 || shown[0] != test.text {
+			t.Errorf("%s: reassembled as %q", test.name, shown)
+		}
+	}
+}
+
+func TestSplitTextFits(t *testing.T) {
+	if parts := splitText("hello", 10); len(parts) != 1 || parts[0] != "hello" {
+		t.Errorf("text that fits split into %q", parts)
+	}
+	if parts := splitText("hello world", 3); parts != nil {
+		t.Errorf("text split with no room for the numbering into %q", parts)
+	}
+}
+
+func TestMessagePartsOutOfOrder(t *testing.T) {
+	text := strings.Repeat("out of order ", 60)
+	parts := splitText(text, textBudget(0, 0, false))
+	if len(parts) != 4 {
+		t.Fatalf("split into %d parts, want 4", len(parts))
+	}
+
+	for _, order := range [][]int{{3, 2, 1, 0}, {1, 3, 0, 2}, {3, 0, 1, 2}} {
+		shown := reassemble(t, parts, order)
+		if len(shown) != 1 || shown[0] != text {
+			t.Errorf("parts in order %v reassembled as %q", order, shown)
+		}
+	}
+}
+
+func TestLooksSplit(t *testing.T) {
+	// Messages that only look numbered are shown straight away
+	for _, text := range []string{"1/2 cup of flour", "(1/3) of the way there", "[2/5] stars"} {
+		shown := newMessageAssembler().add(textPacket(text))
+		if len(shown) != 1 || string(shown[0].GetDecoded().GetPayload()) != text {
+			t.Errorf("%q shown as %v", text, shown)
+		}
+	}
+
+	// A last part on its own is held briefly in case the others arrive after it
+	assembler := newMessageAssembler()
+	if shown := assembler.add(textPacket("2/2 done")); len(shown) != 0 {
+		t.Errorf("last part shown straight away")
+	}
+	if expired := assembler.expire(); len(expired) != 0 {
+		t.Errorf("last part expired straight away")
+	}
+	for _, partial := range assembler.partials {
+		partial.started = time.Now().Add(-lonePartAge - time.Second)
+	}
+	if expired := assembler.expire(); len(expired) != 1 || string(expired[0].GetDecoded().GetPayload()) != "2/2 done" {
+		t.Errorf("last part on its own expired as %v", expired)
+	}
+}
+
+func TestMessagePartsExpire(t *testing.T) {
+	parts := splitText(strings.Repeat("missing part ", 40), textBudget(0, 0, false))
+	if len(parts) != 3 {
+		t.Fatalf("split into %d parts, want 3", len(parts))
+	}
+
+	assembler := newMessageAssembler()
+	assembler.add(textPacket(parts[0]))
+	assembler.add(textPacket(parts[2]))
+	for _, partial := range assembler.partials {
+		partial.started = time.Now().Add(-lonePartAge - time.Second)
+	}
+	if expired := assembler.expire(); len(expired) != 0 {
+		t.Errorf("%d parts expired before the partial message age", len(expired))
+	}
+
+	for _, partial := range assembler.partials {
+		partial.started = time.Now().Add(-partialMessageAge - time.Second)
+	}
+	expired := assembler.expire()
+	if len(expired) != 2 || string(expired[0].GetDecoded().GetPayload()) != parts[0] || string(expired[1].GetDecoded().GetPayload()) != parts[2] {
+		t.Errorf("expired %v, want parts 1 and 3", expired)
+	}
+}
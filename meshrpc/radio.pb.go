@@ -0,0 +1,419 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: radio.proto
+
+package meshrpc
+
+import (
+	gomeshproto "github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RadioInfo struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Packets []*gomeshproto.FromRadio `protobuf:"bytes,1,rep,name=packets,proto3" json:"packets,omitempty"`
+}
+
+func (x *RadioInfo) Reset() {
+	*x = RadioInfo{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_radio_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RadioInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RadioInfo) ProtoMessage() {}
+
+func (x *RadioInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_radio_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RadioInfo.ProtoReflect.Descriptor instead.
+func (*RadioInfo) Descriptor() ([]byte, []int) {
+	return file_radio_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *RadioInfo) GetPackets() []*gomeshproto.FromRadio {
+	if x != nil {
+		return x.Packets
+	}
+	return nil
+}
+
+type Channels struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Channels []*gomeshproto.Channel `protobuf:"bytes,1,rep,name=channels,proto3" json:"channels,omitempty"`
+}
+
+func (x *Channels) Reset() {
+	*x = Channels{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_radio_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Channels) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Channels) ProtoMessage() {}
+
+func (x *Channels) ProtoReflect() protoreflect.Message {
+	mi := &file_radio_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Channels.ProtoReflect.Descriptor instead.
+func (*Channels) Descriptor() ([]byte, []int) {
+	return file_radio_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Channels) GetChannels() []*gomeshproto.Channel {
+	if x != nil {
+		return x.Channels
+	}
+	return nil
+}
+
+type TextMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Text string `protobuf:"bytes,1,opt,name=text,proto3" json:"text,omitempty"`
+	// Node number to send the message to. Leave unset to broadcast
+	To uint32 `protobuf:"varint,2,opt,name=to,proto3" json:"to,omitempty"`
+	// Channel index to send the message on
+	Channel uint32 `protobuf:"varint,3,opt,name=channel,proto3" json:"channel,omitempty"`
+}
+
+func (x *TextMessage) Reset() {
+	*x = TextMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_radio_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TextMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TextMessage) ProtoMessage() {}
+
+func (x *TextMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_radio_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TextMessage.ProtoReflect.Descriptor instead.
+func (*TextMessage) Descriptor() ([]byte, []int) {
+	return file_radio_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *TextMessage) GetText() string {
+	if x != nil {
+		return x.Text
+	}
+	return ""
+}
+
+func (x *TextMessage) GetTo() uint32 {
+	if x != nil {
+		return x.To
+	}
+	return 0
+}
+
+func (x *TextMessage) GetChannel() uint32 {
+	if x != nil {
+		return x.Channel
+	}
+	return 0
+}
+
+type SentMessage struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// ID of the packet the message was sent in
+	Id uint32 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+}
+
+func (x *SentMessage) Reset() {
+	*x = SentMessage{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_radio_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SentMessage) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SentMessage) ProtoMessage() {}
+
+func (x *SentMessage) ProtoReflect() protoreflect.Message {
+	mi := &file_radio_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SentMessage.ProtoReflect.Descriptor instead.
+func (*SentMessage) Descriptor() ([]byte, []int) {
+	return file_radio_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SentMessage) GetId() uint32 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+var File_radio_proto protoreflect.FileDescriptor
+
+var file_radio_proto_rawDesc = []byte{
+	0x0a, 0x0b, 0x72, 0x61, 0x64, 0x69, 0x6f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0c, 0x6d,
+	0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x67, 0x6f, 0x1a, 0x1b, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x65, 0x6d, 0x70,
+	0x74, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x18, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61,
+	0x73, 0x74, 0x69, 0x63, 0x2f, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x1a, 0x17, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x2f, 0x63,
+	0x6f, 0x6e, 0x66, 0x69, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x15, 0x6d, 0x65, 0x73,
+	0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x2f, 0x6d, 0x65, 0x73, 0x68, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x22, 0x3c, 0x0a, 0x09, 0x52, 0x61, 0x64, 0x69, 0x6f, 0x49, 0x6e, 0x66, 0x6f, 0x12,
+	0x2f, 0x0a, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x15, 0x2e, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x2e, 0x46, 0x72,
+	0x6f, 0x6d, 0x52, 0x61, 0x64, 0x69, 0x6f, 0x52, 0x07, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x73,
+	0x22, 0x3b, 0x0a, 0x08, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x73, 0x12, 0x2f, 0x0a, 0x08,
+	0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x13,
+	0x2e, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x2e, 0x43, 0x68, 0x61, 0x6e,
+	0x6e, 0x65, 0x6c, 0x52, 0x08, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x73, 0x22, 0x4b, 0x0a,
+	0x0b, 0x54, 0x65, 0x78, 0x74, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x12, 0x0a, 0x04,
+	0x74, 0x65, 0x78, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x74, 0x65, 0x78, 0x74,
+	0x12, 0x0e, 0x0a, 0x02, 0x74, 0x6f, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x02, 0x74, 0x6f,
+	0x12, 0x18, 0x0a, 0x07, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x07, 0x63, 0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x22, 0x1d, 0x0a, 0x0b, 0x53, 0x65,
+	0x6e, 0x74, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x0e, 0x0a, 0x02, 0x69, 0x64, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x02, 0x69, 0x64, 0x32, 0x84, 0x04, 0x0a, 0x05, 0x52, 0x61,
+	0x64, 0x69, 0x6f, 0x12, 0x3f, 0x0a, 0x0c, 0x47, 0x65, 0x74, 0x52, 0x61, 0x64, 0x69, 0x6f, 0x49,
+	0x6e, 0x66, 0x6f, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x17, 0x2e, 0x6d, 0x65,
+	0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x67, 0x6f, 0x2e, 0x52, 0x61, 0x64, 0x69, 0x6f,
+	0x49, 0x6e, 0x66, 0x6f, 0x12, 0x3d, 0x0a, 0x0b, 0x47, 0x65, 0x74, 0x43, 0x68, 0x61, 0x6e, 0x6e,
+	0x65, 0x6c, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x6d, 0x65,
+	0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x67, 0x6f, 0x2e, 0x43, 0x68, 0x61, 0x6e, 0x6e,
+	0x65, 0x6c, 0x73, 0x12, 0x39, 0x0a, 0x0a, 0x53, 0x65, 0x74, 0x43, 0x68, 0x61, 0x6e, 0x6e, 0x65,
+	0x6c, 0x12, 0x13, 0x2e, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x2e, 0x43,
+	0x68, 0x61, 0x6e, 0x6e, 0x65, 0x6c, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x3c,
+	0x0a, 0x0e, 0x53, 0x65, 0x74, 0x52, 0x61, 0x64, 0x69, 0x6f, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x12, 0x12, 0x2e, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x2e, 0x43, 0x6f,
+	0x6e, 0x66, 0x69, 0x67, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x12, 0x47, 0x0a, 0x0f,
+	0x53, 0x65, 0x6e, 0x64, 0x54, 0x65, 0x78, 0x74, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12,
+	0x19, 0x2e, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x67, 0x6f, 0x2e, 0x54,
+	0x65, 0x78, 0x74, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x1a, 0x19, 0x2e, 0x6d, 0x65, 0x73,
+	0x68, 0x74, 0x61, 0x73, 0x74, 0x69, 0x63, 0x67, 0x6f, 0x2e, 0x53, 0x65, 0x6e, 0x74, 0x4d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x3b, 0x0a, 0x0b, 0x53, 0x65, 0x74, 0x4c, 0x6f, 0x63, 0x61,
+	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x14, 0x2e, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69,
+	0x63, 0x2e, 0x50, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x12, 0x3e, 0x0a, 0x0c, 0x46, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x79, 0x52, 0x65, 0x73,
+	0x65, 0x74, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x12, 0x3c, 0x0a, 0x09, 0x53, 0x75, 0x62, 0x73, 0x63, 0x72, 0x69, 0x62, 0x65, 0x12,
+	0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x15, 0x2e, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61,
+	0x73, 0x74, 0x69, 0x63, 0x2e, 0x46, 0x72, 0x6f, 0x6d, 0x52, 0x61, 0x64, 0x69, 0x6f, 0x30, 0x01,
+	0x42, 0x2a, 0x5a, 0x28, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x6c,
+	0x6d, 0x61, 0x74, 0x74, 0x65, 0x37, 0x2f, 0x6d, 0x65, 0x73, 0x68, 0x74, 0x61, 0x73, 0x74, 0x69,
+	0x63, 0x2d, 0x67, 0x6f, 0x2f, 0x6d, 0x65, 0x73, 0x68, 0x72, 0x70, 0x63, 0x62, 0x06, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_radio_proto_rawDescOnce sync.Once
+	file_radio_proto_rawDescData = file_radio_proto_rawDesc
+)
+
+func file_radio_proto_rawDescGZIP() []byte {
+	file_radio_proto_rawDescOnce.Do(func() {
+		file_radio_proto_rawDescData = protoimpl.X.CompressGZIP(file_radio_proto_rawDescData)
+	})
+	return file_radio_proto_rawDescData
+}
+
+var file_radio_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_radio_proto_goTypes = []interface{}{
+	(*RadioInfo)(nil),             // 0: meshtasticgo.RadioInfo
+	(*Channels)(nil),              // 1: meshtasticgo.Channels
+	(*TextMessage)(nil),           // 2: meshtasticgo.TextMessage
+	(*SentMessage)(nil),           // 3: meshtasticgo.SentMessage
+	(*gomeshproto.FromRadio)(nil), // 4: meshtastic.FromRadio
+	(*gomeshproto.Channel)(nil),   // 5: meshtastic.Channel
+	(*emptypb.Empty)(nil),         // 6: google.protobuf.Empty
+	(*gomeshproto.Config)(nil),    // 7: meshtastic.Config
+	(*gomeshproto.Position)(nil),  // 8: meshtastic.Position
+}
+var file_radio_proto_depIdxs = []int32{
+	4,  // 0: meshtasticgo.RadioInfo.packets:type_name -> meshtastic.FromRadio
+	5,  // 1: meshtasticgo.Channels.channels:type_name -> meshtastic.Channel
+	6,  // 2: meshtasticgo.Radio.GetRadioInfo:input_type -> google.protobuf.Empty
+	6,  // 3: meshtasticgo.Radio.GetChannels:input_type -> google.protobuf.Empty
+	5,  // 4: meshtasticgo.Radio.SetChannel:input_type -> meshtastic.Channel
+	7,  // 5: meshtasticgo.Radio.SetRadioConfig:input_type -> meshtastic.Config
+	2,  // 6: meshtasticgo.Radio.SendTextMessage:input_type -> meshtasticgo.TextMessage
+	8,  // 7: meshtasticgo.Radio.SetLocation:input_type -> meshtastic.Position
+	6,  // 8: meshtasticgo.Radio.FactoryReset:input_type -> google.protobuf.Empty
+	6,  // 9: meshtasticgo.Radio.Subscribe:input_type -> google.protobuf.Empty
+	0,  // 10: meshtasticgo.Radio.GetRadioInfo:output_type -> meshtasticgo.RadioInfo
+	1,  // 11: meshtasticgo.Radio.GetChannels:output_type -> meshtasticgo.Channels
+	6,  // 12: meshtasticgo.Radio.SetChannel:output_type -> google.protobuf.Empty
+	6,  // 13: meshtasticgo.Radio.SetRadioConfig:output_type -> google.protobuf.Empty
+	3,  // 14: meshtasticgo.Radio.SendTextMessage:output_type -> meshtasticgo.SentMessage
+	6,  // 15: meshtasticgo.Radio.SetLocation:output_type -> google.protobuf.Empty
+	6,  // 16: meshtasticgo.Radio.FactoryReset:output_type -> google.protobuf.Empty
+	4,  // 17: meshtasticgo.Radio.Subscribe:output_type -> meshtastic.FromRadio
+	10, // [10:18] is the sub-list for method output_type
+	2,  // [2:10] is the sub-list for method input_type
+	2,  // [2:2] is the sub-list for extension type_name
+	2,  // [2:2] is the sub-list for extension extendee
+	0,  // [0:2] is the sub-list for field type_name
+}
+
+func init() { file_radio_proto_init() }
+func file_radio_proto_init() {
+	if File_radio_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_radio_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RadioInfo); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_radio_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Channels); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_radio_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TextMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_radio_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SentMessage); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_radio_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_radio_proto_goTypes,
+		DependencyIndexes: file_radio_proto_depIdxs,
+		MessageInfos:      file_radio_proto_msgTypes,
+	}.Build()
+	File_radio_proto = out.File
+	file_radio_proto_rawDesc = nil
+	file_radio_proto_goTypes = nil
+	file_radio_proto_depIdxs = nil
+}
@@ -0,0 +1,405 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: radio.proto
+
+package meshrpc
+
+import (
+	context "context"
+	gomeshproto "github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// RadioClient is the client API for Radio service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type RadioClient interface {
+	// GetRadioInfo returns the packets the radio sends when a client connects, including its node
+	// info, node database, channels and config
+	GetRadioInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*RadioInfo, error)
+	// GetChannels returns the channels on the radio
+	GetChannels(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Channels, error)
+	// SetChannel replaces the channel at the index of the given channel
+	SetChannel(ctx context.Context, in *gomeshproto.Channel, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SetRadioConfig replaces the config section set in the given config
+	SetRadioConfig(ctx context.Context, in *gomeshproto.Config, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// SendTextMessage sends a text message to a node, or to every node if to is 0
+	SendTextMessage(ctx context.Context, in *TextMessage, opts ...grpc.CallOption) (*SentMessage, error)
+	// SetLocation sets the position of the radio
+	SetLocation(ctx context.Context, in *gomeshproto.Position, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// FactoryReset resets the radio to its default settings
+	FactoryReset(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error)
+	// Subscribe streams every packet received from the radio until the client cancels
+	Subscribe(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Radio_SubscribeClient, error)
+}
+
+type radioClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewRadioClient(cc grpc.ClientConnInterface) RadioClient {
+	return &radioClient{cc}
+}
+
+func (c *radioClient) GetRadioInfo(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*RadioInfo, error) {
+	out := new(RadioInfo)
+	err := c.cc.Invoke(ctx, "/meshtasticgo.Radio/GetRadioInfo", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *radioClient) GetChannels(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Channels, error) {
+	out := new(Channels)
+	err := c.cc.Invoke(ctx, "/meshtasticgo.Radio/GetChannels", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *radioClient) SetChannel(ctx context.Context, in *gomeshproto.Channel, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/meshtasticgo.Radio/SetChannel", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *radioClient) SetRadioConfig(ctx context.Context, in *gomeshproto.Config, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/meshtasticgo.Radio/SetRadioConfig", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *radioClient) SendTextMessage(ctx context.Context, in *TextMessage, opts ...grpc.CallOption) (*SentMessage, error) {
+	out := new(SentMessage)
+	err := c.cc.Invoke(ctx, "/meshtasticgo.Radio/SendTextMessage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *radioClient) SetLocation(ctx context.Context, in *gomeshproto.Position, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/meshtasticgo.Radio/SetLocation", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *radioClient) FactoryReset(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	out := new(emptypb.Empty)
+	err := c.cc.Invoke(ctx, "/meshtasticgo.Radio/FactoryReset", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *radioClient) Subscribe(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (Radio_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Radio_ServiceDesc.Streams[0], "/meshtasticgo.Radio/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &radioSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Radio_SubscribeClient interface {
+	Recv() (*gomeshproto.FromRadio, error)
+	grpc.ClientStream
+}
+
+type radioSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *radioSubscribeClient) Recv() (*gomeshproto.FromRadio, error) {
+	m := new(gomeshproto.FromRadio)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// RadioServer is the server API for Radio service.
+// All implementations must embed UnimplementedRadioServer
+// for forward compatibility
+type RadioServer interface {
+	// GetRadioInfo returns the packets the radio sends when a client connects, including its node
+	// info, node database, channels and config
+	GetRadioInfo(context.Context, *emptypb.Empty) (*RadioInfo, error)
+	// GetChannels returns the channels on the radio
+	GetChannels(context.Context, *emptypb.Empty) (*Channels, error)
+	// SetChannel replaces the channel at the index of the given channel
+	SetChannel(context.Context, *gomeshproto.Channel) (*emptypb.Empty, error)
+	// SetRadioConfig replaces the config section set in the given config
+	SetRadioConfig(context.Context, *gomeshproto.Config) (*emptypb.Empty, error)
+	// SendTextMessage sends a text message to a node, or to every node if to is 0
+	SendTextMessage(context.Context, *TextMessage) (*SentMessage, error)
+	// SetLocation sets the position of the radio
+	SetLocation(context.Context, *gomeshproto.Position) (*emptypb.Empty, error)
+	// FactoryReset resets the radio to its default settings
+	FactoryReset(context.Context, *emptypb.Empty) (*emptypb.Empty, error)
+	// Subscribe streams every packet received from the radio until the client cancels
+	Subscribe(*emptypb.Empty, Radio_SubscribeServer) error
+	mustEmbedUnimplementedRadioServer()
+}
+
+// UnimplementedRadioServer must be embedded to have forward compatible implementations.
+type UnimplementedRadioServer struct {
+}
+
+func (UnimplementedRadioServer) GetRadioInfo(context.Context, *emptypb.Empty) (*RadioInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetRadioInfo not implemented")
+}
+func (UnimplementedRadioServer) GetChannels(context.Context, *emptypb.Empty) (*Channels, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetChannels not implemented")
+}
+func (UnimplementedRadioServer) SetChannel(context.Context, *gomeshproto.Channel) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetChannel not implemented")
+}
+func (UnimplementedRadioServer) SetRadioConfig(context.Context, *gomeshproto.Config) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetRadioConfig not implemented")
+}
+func (UnimplementedRadioServer) SendTextMessage(context.Context, *TextMessage) (*SentMessage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendTextMessage not implemented")
+}
+func (UnimplementedRadioServer) SetLocation(context.Context, *gomeshproto.Position) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SetLocation not implemented")
+}
+func (UnimplementedRadioServer) FactoryReset(context.Context, *emptypb.Empty) (*emptypb.Empty, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method FactoryReset not implemented")
+}
+func (UnimplementedRadioServer) Subscribe(*emptypb.Empty, Radio_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedRadioServer) mustEmbedUnimplementedRadioServer() {}
+
+// UnsafeRadioServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to RadioServer will
+// result in compilation errors.
+type UnsafeRadioServer interface {
+	mustEmbedUnimplementedRadioServer()
+}
+
+func RegisterRadioServer(s grpc.ServiceRegistrar, srv RadioServer) {
+	s.RegisterService(&Radio_ServiceDesc, srv)
+}
+
+func _Radio_GetRadioInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RadioServer).GetRadioInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meshtasticgo.Radio/GetRadioInfo",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RadioServer).GetRadioInfo(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Radio_GetChannels_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RadioServer).GetChannels(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meshtasticgo.Radio/GetChannels",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RadioServer).GetChannels(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Radio_SetChannel_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(gomeshproto.Channel)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RadioServer).SetChannel(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meshtasticgo.Radio/SetChannel",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RadioServer).SetChannel(ctx, req.(*gomeshproto.Channel))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Radio_SetRadioConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(gomeshproto.Config)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RadioServer).SetRadioConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meshtasticgo.Radio/SetRadioConfig",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RadioServer).SetRadioConfig(ctx, req.(*gomeshproto.Config))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Radio_SendTextMessage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(TextMessage)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RadioServer).SendTextMessage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meshtasticgo.Radio/SendTextMessage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RadioServer).SendTextMessage(ctx, req.(*TextMessage))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Radio_SetLocation_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(gomeshproto.Position)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RadioServer).SetLocation(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meshtasticgo.Radio/SetLocation",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RadioServer).SetLocation(ctx, req.(*gomeshproto.Position))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Radio_FactoryReset_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RadioServer).FactoryReset(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/meshtasticgo.Radio/FactoryReset",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RadioServer).FactoryReset(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Radio_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(emptypb.Empty)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RadioServer).Subscribe(m, &radioSubscribeServer{stream})
+}
+
+type Radio_SubscribeServer interface {
+	Send(*gomeshproto.FromRadio) error
+	grpc.ServerStream
+}
+
+type radioSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *radioSubscribeServer) Send(m *gomeshproto.FromRadio) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Radio_ServiceDesc is the grpc.ServiceDesc for Radio service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Radio_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "meshtasticgo.Radio",
+	HandlerType: (*RadioServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetRadioInfo",
+			Handler:    _Radio_GetRadioInfo_Handler,
+		},
+		{
+			MethodName: "GetChannels",
+			Handler:    _Radio_GetChannels_Handler,
+		},
+		{
+			MethodName: "SetChannel",
+			Handler:    _Radio_SetChannel_Handler,
+		},
+		{
+			MethodName: "SetRadioConfig",
+			Handler:    _Radio_SetRadioConfig_Handler,
+		},
+		{
+			MethodName: "SendTextMessage",
+			Handler:    _Radio_SendTextMessage_Handler,
+		},
+		{
+			MethodName: "SetLocation",
+			Handler:    _Radio_SetLocation_Handler,
+		},
+		{
+			MethodName: "FactoryReset",
+			Handler:    _Radio_FactoryReset_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Radio_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "radio.proto",
+}
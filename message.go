@@ -1,21 +1,55 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/lmatte7/gomesh"
 	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
 	"github.com/urfave/cli/v2"
 )
 
+// newlinePattern matches the line breaks removed from messages shown in a table
+var newlinePattern = regexp.MustCompile(`\r?\n`)
+
+// errStopListening is returned by a listenPackets handler to stop listening
+var errStopListening = errors.New("stop listening")
+
 func getReceivedMessages(c *cli.Context) error {
 
+	filter, err := filterFromFlags(c)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
 	radio := getRadio(c)
 	defer radio.Close()
 
+	// With --mark-seen, messages seen here aren't shown again by message fetch-history. This is only a
+	// convenience, so problems with the seen messages file are reported without stopping
+	var seenPath string
+	var seen map[uint32]int64
+	if c.Bool("mark-seen") {
+		seenPath, err = dataPath("seen-messages.json")
+		if err == nil {
+			seen, err = loadSeenMessages(seenPath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Not marking messages as seen: %v\n", err)
+			seen = nil
+		}
+	}
+
 	if !c.Bool("json") {
 		printMessageHeader()
 	}
+	assembler := newMessageAssembler()
+	threads := newMessageThreads()
 	for {
 
 		responses, err := radio.ReadResponse(false)
@@ -23,21 +57,38 @@ func getReceivedMessages(c *cli.Context) error {
 			return cli.Exit(err.Error(), 0)
 		}
 
-		receivedMessages := []*gomeshproto.FromRadio_Packet{}
-
+		ready := assembler.expire()
 		for _, response := range responses {
 			if packet, ok := response.GetPayloadVariant().(*gomeshproto.FromRadio_Packet); ok {
 				if packet.Packet.GetDecoded().GetPortnum() == gomeshproto.PortNum_TEXT_MESSAGE_APP {
-					receivedMessages = append(receivedMessages, packet)
+					// Parts of long messages are held until the whole message can be shown
+					ready = append(ready, assembler.add(packet.Packet)...)
 				}
 			}
 		}
 
+		// Filters apply to whole messages so text matches can span the parts of a long message
+		receivedMessages := []*gomeshproto.FromRadio_Packet{}
+		for _, message := range ready {
+			if filter.match(message) {
+				receivedMessages = append(receivedMessages, &gomeshproto.FromRadio_Packet{Packet: message})
+			}
+		}
+
 		if len(receivedMessages) > 0 {
+			if seen != nil {
+				for _, message := range receivedMessages {
+					seen[message.Packet.Id] = time.Now().Unix()
+				}
+				if err := saveSeenMessages(seenPath, seen); err != nil {
+					fmt.Fprintf(os.Stderr, "Couldn't save seen messages: %v\n", err)
+				}
+			}
+
 			if c.Bool("json") {
 				printJsonMessages(receivedMessages)
 			} else {
-				printMessages(receivedMessages)
+				printMessages(receivedMessages, threads)
 			}
 			if c.Bool("exit") {
 				return nil
@@ -47,12 +98,120 @@ func getReceivedMessages(c *cli.Context) error {
 
 }
 
+// listenPackets reads from the radio and passes each decoded mesh packet to handler. It returns when the
+// handler returns an error, or once the deadline has passed if one is set. Returning errStopListening
+// from the handler stops listening without an error
+func listenPackets(r gomesh.Radio, deadline time.Time, handler func(packet *gomeshproto.MeshPacket) error) error {
+	for deadline.IsZero() || time.Now().Before(deadline) {
+		responses, err := r.ReadResponse(false)
+		if err != nil {
+			return err
+		}
+
+		for _, response := range responses {
+			packet := response.GetPacket()
+			if packet.GetDecoded() == nil {
+				continue
+			}
+			if err := handler(packet); err != nil {
+				if errors.Is(err, errStopListening) {
+					return nil
+				}
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func sendText(c *cli.Context) error {
 
+	to := uint32(c.Int64("to"))
+	if to == 0 {
+		to = broadcastNum
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	var publicKey []byte
+	switch c.String("pki") {
+	case "prefer", "require":
+		if to == broadcastNum {
+			if c.String("pki") == "require" {
+				return cli.Exit("Public key encryption is only for direct messages, use --to", 0)
+			}
+			break
+		}
+		key, err := destinationKey(radio, to)
+		switch {
+		case errors.Is(err, errKeyStore) && c.String("pki") == "prefer":
+			fmt.Fprintf(os.Stderr, "Can't check the public key for %s (%v), sending with the channel key\n", nodeID(to), err)
+		case err != nil:
+			return cli.Exit(err, 0)
+		case key == nil && c.String("pki") == "require":
+			return cli.Exit(fmt.Sprintf("No public key known for %s", nodeID(to)), 0)
+		case key == nil:
+			fmt.Fprintf(os.Stderr, "No public key known for %s, sending with the channel key\n", nodeID(to))
+		}
+		publicKey = key
+	case "off":
+	default:
+		return cli.Exit("--pki must be prefer, require or off", 0)
+	}
+
+	var replyID uint32
+	if c.IsSet("reply-to") {
+		id, err := strconv.ParseUint(c.String("reply-to"), 0, 32)
+		if err != nil {
+			return cli.Exit(fmt.Sprintf("Invalid packet ID %s", c.String("reply-to")), 0)
+		}
+		replyID = uint32(id)
+	}
+
+	text := c.String("message")
+	if budget := textBudget(replyID, 0, publicKey != nil); len(text) > budget {
+		switch c.String("overflow") {
+		case "split":
+			fmt.Fprintf(os.Stderr, "The %s, sending it in %d parts\n", textOverage(text, budget), len(splitText(text, budget)))
+		case "truncate":
+			fmt.Fprintf(os.Stderr, "The %s, truncating it\n", textOverage(text, budget))
+			text = truncateText(text, budget)
+		case "error":
+			return cli.Exit(fmt.Sprintf("The %s. Use --overflow split or --overflow truncate to send it anyway", textOverage(text, budget)), 0)
+		default:
+			return cli.Exit("--overflow must be split, truncate or error", 0)
+		}
+	}
+
+	_, err := sendLongText(radio, text, to, uint32(c.Int64("channel")), replyID, publicKey)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+func sendReaction(c *cli.Context) error {
+
+	if c.NArg() != 2 {
+		return cli.Exit("Packet ID and emoji are required", 0)
+	}
+	replyID, err := strconv.ParseUint(c.Args().Get(0), 0, 32)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid packet ID %s", c.Args().Get(0)), 0)
+	}
+
+	to := uint32(c.Int64("to"))
+	if to == 0 {
+		to = broadcastNum
+	}
+
 	radio := getRadio(c)
 	defer radio.Close()
 
-	err := radio.SendTextMessage(c.String("message"), c.Int64("to"), c.Int64("channel"))
+	_, err = sendTextMessage(radio, c.Args().Get(1), to, uint32(c.Int64("channel")), uint32(replyID), 1, nil)
 	if err != nil {
 		return cli.Exit(err, 0)
 	}
@@ -64,7 +223,8 @@ func printMessageHeader() {
 	fmt.Printf("\n")
 	fmt.Printf("Received Messages:\n")
 	printDoubleDivider()
-	fmt.Printf("| %-15s| ", "From")
+	fmt.Printf("| %-12s| ", "ID")
+	fmt.Printf("%-15s| ", "From")
 	fmt.Printf("%-15s| ", "To")
 	fmt.Printf("%-18s| ", "Port Num")
 	fmt.Printf("%-10s| ", "Channel")
@@ -72,28 +232,132 @@ func printMessageHeader() {
 	printSingleDivider()
 }
 
-func printMessages(messages []*gomeshproto.FromRadio_Packet) {
+func printMessages(messages []*gomeshproto.FromRadio_Packet, threads *messageThreads) {
 	for _, message := range messages {
-		fmt.Printf("| %-15s| ", fmt.Sprint(message.Packet.From))
+		fmt.Printf("| %-12s| ", fmt.Sprint(message.Packet.Id))
+		fmt.Printf("%-15s| ", fmt.Sprint(message.Packet.From))
 		fmt.Printf("%-15s| ", fmt.Sprint(message.Packet.To))
 		fmt.Printf("%-18s| ", message.Packet.GetDecoded().GetPortnum().String())
 		fmt.Printf("%-10s| ", fmt.Sprint(message.Packet.Channel))
-		re := regexp.MustCompile(`\r?\n`)
-		escMesg := re.ReplaceAllString(string(message.Packet.GetDecoded().Payload), "")
-		fmt.Printf("%-53q", escMesg)
+		fmt.Printf("%-53s", threads.text(message.Packet.Id, message.Packet.GetDecoded()))
 		fmt.Printf("%s", "|\n")
 	}
 }
 
 func printJsonMessages(messages []*gomeshproto.FromRadio_Packet) {
 	for _, message := range messages {
-		fmt.Printf("{\"from\":%s,", fmt.Sprint(message.Packet.From))
+		fmt.Printf("{\"id\":%s,", fmt.Sprint(message.Packet.Id))
+		fmt.Printf("\"from\":%s,", fmt.Sprint(message.Packet.From))
 		fmt.Printf("\"to\":%s,", fmt.Sprint(message.Packet.To))
 		fmt.Printf("\"portnum\": \"%s\",", message.Packet.GetDecoded().GetPortnum().String())
 		fmt.Printf("\"channel\":%s,", fmt.Sprint(message.Packet.Channel))
+		fmt.Printf("\"reply_id\":%s,", fmt.Sprint(message.Packet.GetDecoded().GetReplyId()))
+		fmt.Printf("\"emoji\":%s,", fmt.Sprint(message.Packet.GetDecoded().GetEmoji() != 0))
 		re := regexp.MustCompile(`\r?\n`)
 		escMesg := re.ReplaceAllString(string(message.Packet.GetDecoded().Payload), "")
 		fmt.Printf("\"Payload\": \"%s\"", escMesg)
 		fmt.Printf("%s", "}\n")
 	}
 }
+
+// threadMemory is how many recently shown messages recv remembers for threading replies and reactions
+const threadMemory = 200
+
+// messageThreads remembers the messages recv has shown recently, so replies to them can be indented under
+// them and reactions to them counted
+type messageThreads struct {
+	order     []uint32
+	depth     map[uint32]int
+	reactions map[uint32][]string
+}
+
+func newMessageThreads() *messageThreads {
+	return &messageThreads{depth: make(map[uint32]int), reactions: make(map[uint32][]string)}
+}
+
+// text returns the text to show for a message as it arrives. Replies to a message shown recently are
+// indented one level deeper than it, and reactions to it are shown indented under it as the count of each
+// emoji it has received so far, in the same way as fetch-history. Replies and reactions to older messages
+// are marked with the ID of the message they belong to
+func (t *messageThreads) text(id uint32, data *gomeshproto.Data) string {
+	quote := func(text string) string {
+		return fmt.Sprintf("%q", newlinePattern.ReplaceAllString(text, ""))
+	}
+
+	parent, ok := t.depth[data.GetReplyId()]
+	if data.GetReplyId() == 0 || !ok {
+		if data.GetEmoji() == 0 {
+			t.remember(id, 0)
+		}
+		return quote(threadText(data))
+	}
+
+	indent := strings.Repeat("  ", parent) + "↳ "
+	if data.GetEmoji() != 0 {
+		t.reactions[data.ReplyId] = append(t.reactions[data.ReplyId], string(data.GetPayload()))
+		return indent + countReactions(t.reactions[data.ReplyId])
+	}
+
+	t.remember(id, parent+1)
+	return indent + quote(string(data.GetPayload()))
+}
+
+// remember records a shown message, forgetting the oldest once threadMemory messages are remembered
+func (t *messageThreads) remember(id uint32, depth int) {
+	if _, ok := t.depth[id]; !ok {
+		t.order = append(t.order, id)
+	}
+	t.depth[id] = depth
+
+	if len(t.order) > threadMemory {
+		delete(t.depth, t.order[0])
+		delete(t.reactions, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// threadText returns the text of a message, marking replies and reactions with the ID of the message
+// they belong to
+func threadText(data *gomeshproto.Data) string {
+	switch {
+	case data.GetReplyId() != 0 && data.GetEmoji() != 0:
+		return fmt.Sprintf("%s reaction to %d", data.Payload, data.ReplyId)
+	case data.GetReplyId() != 0:
+		return fmt.Sprintf("↳ reply to %d: %s", data.ReplyId, data.Payload)
+	}
+
+	return string(data.GetPayload())
+}
+
+// sendTextMessage sends a text message and returns the ID of the packet it was sent in. A reply ID makes
+// it a reply to that message, and setting emoji as well makes it a reaction. The message is encrypted with
+// publicKey instead of the channel key if one is given
+func sendTextMessage(r gomesh.Radio, text string, to uint32, channel uint32, replyID uint32, emoji uint32, publicKey []byte) (uint32, error) {
+	if budget := textBudget(replyID, emoji, publicKey != nil); len(text) > budget {
+		return 0, errors.New(textOverage(text, budget))
+	}
+
+	id := newPacketID()
+	packet := &gomeshproto.MeshPacket{
+		To:      to,
+		WantAck: true,
+		Id:      id,
+		Channel: channel,
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Payload: []byte(text),
+				Portnum: gomeshproto.PortNum_TEXT_MESSAGE_APP,
+				ReplyId: replyID,
+				Emoji:   emoji,
+			},
+		},
+	}
+	if publicKey != nil {
+		setPkiEncrypted(packet, publicKey)
+	}
+	if err := sendPacket(r, packet); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+)
+
+func TestMessageThreads(t *testing.T) {
+	tests := []struct {
+		id      uint32
+		replyID uint32
+		emoji   uint32
+		text    string
+		want    string
+	}{
+		{1, 0, 0, "hello\nthere", `"hellothere"`},
+		{2, 1, 0, "hi", `↳ "hi"`},
+		{3, 2, 0, "how are you", `  ↳ "how are you"`},
+		{4, 1, 1, "👍", "↳ 👍 1"},
+		{5, 3, 1, "❤", "    ↳ ❤ 1"},
+		{6, 1, 1, "👍", "↳ 👍 2"},
+		{7, 99, 0, "late", `"↳ reply to 99: late"`},
+		{8, 99, 1, "👍", `"👍 reaction to 99"`},
+	}
+
+	threads := newMessageThreads()
+	for _, test := range tests {
+		got := threads.text(test.id, &gomeshproto.Data{Payload: []byte(test.text), ReplyId: test.replyID, Emoji: test.emoji})
+		if got != test.want {
+			t.Errorf("message %d shown as %s, want %s", test.id, got, test.want)
+		}
+	}
+}
+
+func TestMessageThreadsForget(t *testing.T) {
+	threads := newMessageThreads()
+	for id := uint32(1); id <= threadMemory+1; id++ {
+		threads.text(id, &gomeshproto.Data{Payload: []byte("hello")})
+	}
+
+	want := fmt.Sprintf("%q", "↳ reply to 1: hi")
+	if got := threads.text(1000, &gomeshproto.Data{Payload: []byte("hi"), ReplyId: 1}); got != want {
+		t.Errorf("reply to a forgotten message shown as %s, want %s", got, want)
+	}
+	if got := threads.text(1001, &gomeshproto.Data{Payload: []byte("hi"), ReplyId: threadMemory + 1}); got != `↳ "hi"` {
+		t.Errorf("reply to a remembered message shown as %s", got)
+	}
+}
@@ -1,11 +1,22 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
 
+	"github.com/golang/protobuf/jsonpb"
 	"github.com/lmatte7/gomesh"
 	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
 	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
 )
 
 func showMetricInfo(c *cli.Context) error {
@@ -13,46 +24,551 @@ func showMetricInfo(c *cli.Context) error {
 	radio := getRadio(c)
 	defer radio.Close()
 
-	return displayMetrics(radio)
+	store, err := collectTelemetry(radio, c.Duration("listen"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	switch {
+	case c.Bool("json"):
+		err = printJsonMetrics(store)
+	case c.Bool("csv"):
+		err = printCsvMetrics(store)
+	default:
+		printMetrics(store)
+	}
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
 }
 
-func displayMetrics(r gomesh.Radio) error {
+// nodeTelemetry is the most recent telemetry of each type received from a node
+type nodeTelemetry struct {
+	Num    uint32
+	Name   string
+	Latest map[string]*latestTelemetry
+}
+
+// latestTelemetry is the readings from the most recent telemetry packet of one type and when it was measured
+type latestTelemetry struct {
+	Time     time.Time
+	Readings []telemetryReading
+}
+
+// telemetryStore keeps the most recent telemetry of each type for every node
+type telemetryStore map[uint32]*nodeTelemetry
+
+// collectTelemetry loads the device metrics from the radio's node database along with any telemetry
+// packets the radio has queued, then listens for more telemetry packets for the given duration
+func collectTelemetry(r gomesh.Radio, listen time.Duration) (telemetryStore, error) {
 	responses, err := r.GetRadioInfo()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	nodes := make([]*gomeshproto.FromRadio_NodeInfo, 0)
+	store := telemetryStore{}
 	for _, response := range responses {
-		if nodeInfo, ok := response.GetPayloadVariant().(*gomeshproto.FromRadio_NodeInfo); ok {
-			nodes = append(nodes, nodeInfo)
+		if info := response.GetNodeInfo(); info != nil {
+			node := store.node(info.Num)
+			if info.User.GetLongName() != "" {
+				node.Name = info.User.LongName
+			}
+			if info.DeviceMetrics != nil {
+				heard := time.Time{}
+				if info.LastHeard > 0 {
+					heard = time.Unix(int64(info.LastHeard), 0)
+				}
+				store.add(info.Num, &gomeshproto.Telemetry{
+					Variant: &gomeshproto.Telemetry_DeviceMetrics{DeviceMetrics: info.DeviceMetrics},
+				}, heard)
+			}
+		}
+		if packet := response.GetPacket(); packet.GetDecoded() != nil {
+			store.addPacket(packet)
+		}
+	}
+
+	if listen > 0 {
+		fmt.Fprintf(os.Stderr, "Listening for telemetry for %s\n", listen)
+		err = listenPackets(r, time.Now().Add(listen), func(packet *gomeshproto.MeshPacket) error {
+			store.addPacket(packet)
+			return nil
+		})
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	printMetrics(nodes)
+	return store, nil
+}
 
-	return nil
+// node returns the telemetry for a node, adding the node if it hasn't been seen before
+func (s telemetryStore) node(num uint32) *nodeTelemetry {
+	node, ok := s[num]
+	if !ok {
+		node = &nodeTelemetry{Num: num, Name: nodeID(num), Latest: make(map[string]*latestTelemetry)}
+		s[num] = node
+	}
+
+	return node
+}
+
+// add keeps the readings from a telemetry packet unless newer telemetry of the same type has been seen
+func (s telemetryStore) add(num uint32, telemetry *gomeshproto.Telemetry, heard time.Time) {
+	readings := telemetryReadings(telemetry)
+	if len(readings) == 0 {
+		return
+	}
+
+	measured := heard
+	if telemetry.Time > 0 {
+		measured = time.Unix(int64(telemetry.Time), 0)
+	}
+
+	node := s.node(num)
+	kind := readings[0].Kind
+	if latest, ok := node.Latest[kind]; ok && latest.Time.After(measured) {
+		return
+	}
+	node.Latest[kind] = &latestTelemetry{Time: measured, Readings: readings}
+}
+
+// addPacket updates the store from TELEMETRY_APP and NODEINFO_APP packets
+func (s telemetryStore) addPacket(packet *gomeshproto.MeshPacket) {
+	heard := time.Now().Truncate(time.Second)
+	if packet.RxTime > 0 {
+		heard = time.Unix(int64(packet.RxTime), 0)
+	}
+
+	switch packet.GetDecoded().GetPortnum() {
+	case gomeshproto.PortNum_TELEMETRY_APP:
+		telemetry := gomeshproto.Telemetry{}
+		if err := unmarshalPayload(packet, &telemetry); err == nil {
+			s.add(packet.From, &telemetry, heard)
+		}
+	case gomeshproto.PortNum_NODEINFO_APP:
+		user := gomeshproto.User{}
+		if err := unmarshalPayload(packet, &user); err == nil && user.LongName != "" {
+			s.node(packet.From).Name = user.LongName
+		}
+	}
 }
 
-func printMetrics(nodes []*gomeshproto.FromRadio_NodeInfo) {
+// sorted returns the nodes that have telemetry ordered by node number
+func (s telemetryStore) sorted() []*nodeTelemetry {
+	nodes := make([]*nodeTelemetry, 0, len(s))
+	for _, node := range s {
+		if len(node.Latest) > 0 {
+			nodes = append(nodes, node)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Num < nodes[j].Num })
+
+	return nodes
+}
+
+// telemetryKinds is the order telemetry types are shown in
+var telemetryKinds = []string{"device", "environment", "power", "air-quality"}
+
+func printMetrics(store telemetryStore) {
 	fmt.Printf("\n")
-	fmt.Printf("Mesh Device Metrics:\n")
+	fmt.Printf("Mesh Telemetry:\n")
 
 	printDoubleDivider()
 	fmt.Printf("| %-15s| ", "Node Number")
-	fmt.Printf("%-15s| ", "Battery")
-	fmt.Printf("%-15s| ", "Voltage")
-	fmt.Printf("%-20s| ", "Channel Utilization")
-	fmt.Printf("%-15s", "AirUtilTx      |\n")
+	fmt.Printf("%-20s| ", "Name")
+	fmt.Printf("%-15s| ", "Type")
+	fmt.Printf("%-30s| ", "Metric")
+	fmt.Printf("%-15s| ", "Value")
+	fmt.Printf("%-25s", "Updated                  |\n")
 	printSingleDivider()
-	for _, node := range nodes {
-		if node.NodeInfo.DeviceMetrics != nil {
-			fmt.Printf("| %-15s| ", fmt.Sprint(node.NodeInfo.Num))
-			fmt.Printf("%-15s| ", fmt.Sprint(node.NodeInfo.DeviceMetrics.BatteryLevel))
-			fmt.Printf("%-15s| ", fmt.Sprint(node.NodeInfo.DeviceMetrics.Voltage))
-			fmt.Printf("%-20s| ", fmt.Sprint(node.NodeInfo.DeviceMetrics.ChannelUtilization))
-			fmt.Printf("%-15s| \n", fmt.Sprint(node.NodeInfo.DeviceMetrics.AirUtilTx))
+	for _, node := range store.sorted() {
+		for _, kind := range telemetryKinds {
+			latest, ok := node.Latest[kind]
+			if !ok {
+				continue
+			}
+			updated := "unknown"
+			if !latest.Time.IsZero() {
+				updated = latest.Time.Format(time.RFC3339)
+			}
+			for _, reading := range latest.Readings {
+				fmt.Printf("| %-15s| ", fmt.Sprint(node.Num))
+				fmt.Printf("%-20s| ", node.Name)
+				fmt.Printf("%-15s| ", kind)
+				fmt.Printf("%-30s| ", reading.Name)
+				fmt.Printf("%-15s| ", fmt.Sprint(reading.Value))
+				fmt.Printf("%-25s|\n", updated)
+			}
+		}
+	}
+	printDoubleDivider()
+}
+
+func printJsonMetrics(store telemetryStore) error {
+	type telemetryJSON struct {
+		Time   *time.Time         `json:"time,omitempty"`
+		Values map[string]float64 `json:"values"`
+	}
+	type nodeJSON struct {
+		ID        string                   `json:"id"`
+		Num       uint32                   `json:"num"`
+		Name      string                   `json:"name"`
+		Telemetry map[string]telemetryJSON `json:"telemetry"`
+	}
+
+	nodes := make([]nodeJSON, 0, len(store))
+	for _, node := range store.sorted() {
+		out := nodeJSON{ID: nodeID(node.Num), Num: node.Num, Name: node.Name, Telemetry: make(map[string]telemetryJSON)}
+		for kind, latest := range node.Latest {
+			values := make(map[string]float64, len(latest.Readings))
+			for _, reading := range latest.Readings {
+				values[reading.Name] = reading.Value
+			}
+			telemetry := telemetryJSON{Values: values}
+			if !latest.Time.IsZero() {
+				measured := latest.Time.UTC()
+				telemetry.Time = &measured
+			}
+			out.Telemetry[kind] = telemetry
 		}
+		nodes = append(nodes, out)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(nodes)
+}
+
+func printCsvMetrics(store telemetryStore) error {
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"node_id", "node_num", "name", "type", "metric", "value", "time"})
+	for _, node := range store.sorted() {
+		for _, kind := range telemetryKinds {
+			latest, ok := node.Latest[kind]
+			if !ok {
+				continue
+			}
+			measured := ""
+			if !latest.Time.IsZero() {
+				measured = latest.Time.UTC().Format(time.RFC3339)
+			}
+			for _, reading := range latest.Readings {
+				w.Write([]string{
+					nodeID(node.Num),
+					fmt.Sprint(node.Num),
+					node.Name,
+					kind,
+					reading.Name,
+					strconv.FormatFloat(reading.Value, 'f', -1, 64),
+					measured,
+				})
+			}
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+// telemetryReading is a single value from a telemetry packet. Kind is device, environment, power or
+// air-quality and Name includes the unit of the value
+type telemetryReading struct {
+	Kind  string
+	Name  string
+	Value float64
+}
+
+// deviceUptimeField is the uptime_seconds field that newer firmware adds to DeviceMetrics
+const deviceUptimeField = 5
+
+// newerEnvironmentFields are EnvironmentMetrics fields sent by newer firmware that aren't in the protobufs
+// included with gomesh
+var newerEnvironmentFields = []struct {
+	number protowire.Number
+	name   string
+}{
+	{7, "iaq"},
+	{8, "distance_mm"},
+	{9, "lux"},
+	{10, "white_lux"},
+	{11, "ir_lux"},
+	{12, "uv_lux"},
+	{13, "wind_direction_degrees"},
+	{14, "wind_speed_mps"},
+	{15, "weight_kg"},
+}
+
+// telemetryHelp describes each telemetry reading
+var telemetryHelp = map[string]string{
+	"battery_level_percent":         "Battery level, over 100 when powered externally",
+	"voltage_volts":                 "Battery voltage",
+	"channel_utilization_percent":   "Utilization of the channel by all nodes the node can hear",
+	"air_util_tx_percent":           "Airtime used by the node for transmitting in the last hour",
+	"temperature_celsius":           "Temperature measured by the environment sensor",
+	"relative_humidity_percent":     "Relative humidity measured by the environment sensor",
+	"barometric_pressure_hpa":       "Barometric pressure measured by the environment sensor",
+	"gas_resistance_mohms":          "Gas resistance measured by the environment sensor",
+	"environment_voltage_volts":     "Voltage measured by the environment sensor",
+	"environment_current_milliamps": "Current measured by the environment sensor",
+	"uptime_seconds":                "Time since the node booted",
+	"iaq":                           "Indoor air quality index measured by the environment sensor",
+	"distance_mm":                   "Distance measured by the environment sensor",
+	"lux":                           "Ambient light measured by the environment sensor",
+	"white_lux":                     "White light measured by the environment sensor",
+	"ir_lux":                        "Infrared light measured by the environment sensor",
+	"uv_lux":                        "Ultraviolet light measured by the environment sensor",
+	"wind_direction_degrees":        "Wind direction measured by the environment sensor",
+	"wind_speed_mps":                "Wind speed measured by the environment sensor",
+	"weight_kg":                     "Weight measured by the environment sensor",
+	"ch1_voltage_volts":             "Voltage of power channel 1",
+	"ch1_current_milliamps":         "Current of power channel 1",
+	"ch2_voltage_volts":             "Voltage of power channel 2",
+	"ch2_current_milliamps":         "Current of power channel 2",
+	"ch3_voltage_volts":             "Voltage of power channel 3",
+	"ch3_current_milliamps":         "Current of power channel 3",
+	"pm10_standard_ugm3":            "PM1.0 concentration, standard",
+	"pm25_standard_ugm3":            "PM2.5 concentration, standard",
+	"pm100_standard_ugm3":           "PM10.0 concentration, standard",
+	"pm10_environmental_ugm3":       "PM1.0 concentration, environmental",
+	"pm25_environmental_ugm3":       "PM2.5 concentration, environmental",
+	"pm100_environmental_ugm3":      "PM10.0 concentration, environmental",
+	"particles_03um_per_dl":         "Particles 0.3um and larger per 0.1L of air",
+	"particles_05um_per_dl":         "Particles 0.5um and larger per 0.1L of air",
+	"particles_10um_per_dl":         "Particles 1.0um and larger per 0.1L of air",
+	"particles_25um_per_dl":         "Particles 2.5um and larger per 0.1L of air",
+	"particles_50um_per_dl":         "Particles 5.0um and larger per 0.1L of air",
+	"particles_100um_per_dl":        "Particles 10.0um and larger per 0.1L of air",
+}
+
+// telemetryReadings returns the values in a telemetry packet. The protobufs can't tell a reading of zero
+// from a sensor that isn't fitted, so zero environment, power and air quality readings are left out
+func telemetryReadings(telemetry *gomeshproto.Telemetry) []telemetryReading {
+	readings := make([]telemetryReading, 0)
+	add := func(kind string, name string, value float64, keepZero bool) {
+		if value != 0 || keepZero {
+			readings = append(readings, telemetryReading{Kind: kind, Name: name, Value: value})
+		}
+	}
+
+	switch variant := telemetry.GetVariant().(type) {
+	case *gomeshproto.Telemetry_DeviceMetrics:
+		metrics := variant.DeviceMetrics
+		add("device", "battery_level_percent", float64(metrics.BatteryLevel), true)
+		add("device", "voltage_volts", widenFloat(metrics.Voltage), true)
+		add("device", "channel_utilization_percent", widenFloat(metrics.ChannelUtilization), true)
+		add("device", "air_util_tx_percent", widenFloat(metrics.AirUtilTx), true)
+		if uptime, ok := unknownNumber(metrics, deviceUptimeField); ok {
+			add("device", "uptime_seconds", uptime, false)
+		}
+	case *gomeshproto.Telemetry_EnvironmentMetrics:
+		metrics := variant.EnvironmentMetrics
+		add("environment", "temperature_celsius", widenFloat(metrics.Temperature), false)
+		add("environment", "relative_humidity_percent", widenFloat(metrics.RelativeHumidity), false)
+		add("environment", "barometric_pressure_hpa", widenFloat(metrics.BarometricPressure), false)
+		add("environment", "gas_resistance_mohms", widenFloat(metrics.GasResistance), false)
+		add("environment", "environment_voltage_volts", widenFloat(metrics.Voltage), false)
+		add("environment", "environment_current_milliamps", widenFloat(metrics.Current), false)
+		for _, field := range newerEnvironmentFields {
+			if value, ok := unknownNumber(metrics, field.number); ok {
+				add("environment", field.name, value, false)
+			}
+		}
+	case *gomeshproto.Telemetry_PowerMetrics:
+		metrics := variant.PowerMetrics
+		add("power", "ch1_voltage_volts", widenFloat(metrics.Ch1Voltage), false)
+		add("power", "ch1_current_milliamps", widenFloat(metrics.Ch1Current), false)
+		add("power", "ch2_voltage_volts", widenFloat(metrics.Ch2Voltage), false)
+		add("power", "ch2_current_milliamps", widenFloat(metrics.Ch2Current), false)
+		add("power", "ch3_voltage_volts", widenFloat(metrics.Ch3Voltage), false)
+		add("power", "ch3_current_milliamps", widenFloat(metrics.Ch3Current), false)
+	case *gomeshproto.Telemetry_AirQualityMetrics:
+		metrics := variant.AirQualityMetrics
+		add("air-quality", "pm10_standard_ugm3", float64(metrics.Pm10Standard), false)
+		add("air-quality", "pm25_standard_ugm3", float64(metrics.Pm25Standard), false)
+		add("air-quality", "pm100_standard_ugm3", float64(metrics.Pm100Standard), false)
+		add("air-quality", "pm10_environmental_ugm3", float64(metrics.Pm10Environmental), false)
+		add("air-quality", "pm25_environmental_ugm3", float64(metrics.Pm25Environmental), false)
+		add("air-quality", "pm100_environmental_ugm3", float64(metrics.Pm100Environmental), false)
+		add("air-quality", "particles_03um_per_dl", float64(metrics.Particles_03Um), false)
+		add("air-quality", "particles_05um_per_dl", float64(metrics.Particles_05Um), false)
+		add("air-quality", "particles_10um_per_dl", float64(metrics.Particles_10Um), false)
+		add("air-quality", "particles_25um_per_dl", float64(metrics.Particles_25Um), false)
+		add("air-quality", "particles_50um_per_dl", float64(metrics.Particles_50Um), false)
+		add("air-quality", "particles_100um_per_dl", float64(metrics.Particles_100Um), false)
+	}
+
+	return readings
+}
+
+// unknownNumber finds a numeric field that isn't in the protobufs included with gomesh in the unknown
+// fields of a message. Fixed32 fields are read as floats and varints as unsigned integers
+func unknownNumber(m proto.Message, number protowire.Number) (float64, bool) {
+	value, found := 0.0, false
+	unknown := m.ProtoReflect().GetUnknown()
+	for len(unknown) > 0 {
+		num, typ, n := protowire.ConsumeTag(unknown)
+		if n < 0 {
+			return 0, false
+		}
+		unknown = unknown[n:]
+
+		switch typ {
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(unknown)
+			if n < 0 {
+				return 0, false
+			}
+			if num == number {
+				value, found = widenFloat(math.Float32frombits(v)), true
+			}
+			unknown = unknown[n:]
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(unknown)
+			if n < 0 {
+				return 0, false
+			}
+			if num == number {
+				value, found = float64(v), true
+			}
+			unknown = unknown[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, unknown)
+			if n < 0 {
+				return 0, false
+			}
+			unknown = unknown[n:]
+		}
+	}
+
+	return value, found
+}
+
+// widenFloat converts a float32 to the float64 with the same shortest decimal representation, so 4.1
+// stays 4.1 instead of becoming 4.099999904632568
+func widenFloat(value float32) float64 {
+	widened, _ := strconv.ParseFloat(strconv.FormatFloat(float64(value), 'g', -1, 32), 64)
+	return widened
+}
+
+func requestMetrics(c *cli.Context) error {
+
+	to, err := parseNodeID(c.String("to"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	if to == broadcastNum {
+		return cli.Exit("Telemetry can only be requested from a single node", 0)
+	}
+
+	request := gomeshproto.Telemetry{}
+	switch c.String("type") {
+	case "device":
+		request.Variant = &gomeshproto.Telemetry_DeviceMetrics{DeviceMetrics: &gomeshproto.DeviceMetrics{}}
+	case "environment":
+		request.Variant = &gomeshproto.Telemetry_EnvironmentMetrics{EnvironmentMetrics: &gomeshproto.EnvironmentMetrics{}}
+	case "power":
+		request.Variant = &gomeshproto.Telemetry_PowerMetrics{PowerMetrics: &gomeshproto.PowerMetrics{}}
+	case "air-quality":
+		request.Variant = &gomeshproto.Telemetry_AirQualityMetrics{AirQualityMetrics: &gomeshproto.AirQualityMetrics{}}
+	default:
+		return cli.Exit("Type must be device, environment, power or air-quality", 0)
+	}
+
+	payload, err := proto.Marshal(&request)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	id := newPacketID()
+	err = sendPacket(radio, &gomeshproto.MeshPacket{
+		To:      to,
+		Id:      id,
+		WantAck: true,
+		Channel: uint32(c.Int64("channel")),
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Payload:      payload,
+				Portnum:      gomeshproto.PortNum_TELEMETRY_APP,
+				WantResponse: true,
+			},
+		},
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("Requested %s metrics from %s, waiting up to %s for a reply\n", c.String("type"), nodeID(to), c.Duration("timeout"))
+
+	var reply *gomeshproto.Telemetry
+	err = listenPackets(radio, time.Now().Add(c.Duration("timeout")), func(packet *gomeshproto.MeshPacket) error {
+		decoded := packet.GetDecoded()
+		if decoded.RequestId != id && (decoded.RequestId != 0 || packet.From != to) {
+			return nil
+		}
+
+		switch decoded.GetPortnum() {
+		case gomeshproto.PortNum_ROUTING_APP:
+			routing := gomeshproto.Routing{}
+			if err := unmarshalPayload(packet, &routing); err == nil && routing.GetErrorReason() != gomeshproto.Routing_NONE {
+				return fmt.Errorf("request failed: %s", routing.GetErrorReason())
+			}
+		case gomeshproto.PortNum_TELEMETRY_APP:
+			telemetry := gomeshproto.Telemetry{}
+			if err := unmarshalPayload(packet, &telemetry); err != nil {
+				return nil
+			}
+			if reflect.TypeOf(telemetry.Variant) != reflect.TypeOf(request.Variant) {
+				return nil
+			}
+			reply = &telemetry
+			return errStopListening
+		}
+
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	if reply == nil {
+		return cli.Exit(fmt.Sprintf("No reply from %s", nodeID(to)), 0)
+	}
+
+	if c.Bool("json") {
+		marshaler := jsonpb.Marshaler{}
+		json, err := marshaler.MarshalToString(reply)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		fmt.Println(json)
+		return nil
+	}
+
+	printTelemetry(to, reply)
+	return nil
+}
+
+// printTelemetry prints the readings in a telemetry packet from a node
+func printTelemetry(num uint32, telemetry *gomeshproto.Telemetry) {
+	measured := "unknown time"
+	if telemetry.Time > 0 {
+		measured = time.Unix(int64(telemetry.Time), 0).Format(time.RFC3339)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Telemetry from %s at %s:\n", nodeID(num), measured)
+	printDoubleDivider()
+	fmt.Printf("| %-35s| ", "Metric")
+	fmt.Printf("%-15s|\n", "Value")
+	printSingleDivider()
+	for _, reading := range telemetryReadings(telemetry) {
+		fmt.Printf("| %-35s| ", reading.Name)
+		fmt.Printf("%-15s|\n", fmt.Sprint(reading.Value))
 	}
 	printDoubleDivider()
 }
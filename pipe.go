@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// pipeHeaderLen is the length of the header on each pipe packet: a 16 bit write sequence number, then the
+// index of the chunk within the write and the number of chunks in it
+const pipeHeaderLen = 4
+
+// pipeMaxChunks is the most chunks a single write can be split into
+const pipeMaxChunks = 255
+
+// pipePartialAge is how long a partly received write is kept waiting for its missing chunks
+const pipePartialAge = 5 * time.Minute
+
+// pipePacket is a packet queued to be sent, or waiting for an ACK
+type pipePacket struct {
+	id      uint32
+	payload []byte
+	sent    time.Time
+	tries   int
+}
+
+// pipeWrite is a write being reassembled from its chunks
+type pipeWrite struct {
+	chunks   [][]byte
+	received int
+	started  time.Time
+}
+
+func runPipe(c *cli.Context) error {
+
+	portNum, err := parsePortNum(c.String("port-num"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	to := uint32(broadcastNum)
+	if c.IsSet("to") {
+		to, err = parseNodeID(c.String("to"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+	channel := uint32(c.Int64("channel"))
+	raw := c.Bool("raw")
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	// Each read from stdin is sent as one write, split into as many chunks as it needs
+	readSize := pipeMaxChunks * (int(gomeshproto.Constants_DATA_PAYLOAD_LEN) - pipeHeaderLen)
+	if raw {
+		readSize = int(gomeshproto.Constants_DATA_PAYLOAD_LEN)
+	}
+	input := make(chan []byte)
+	inputErr := make(chan error, 1)
+	go func() {
+		for {
+			buffer := make([]byte, readSize)
+			n, err := os.Stdin.Read(buffer)
+			if n > 0 {
+				input <- buffer[:n]
+			}
+			if err != nil {
+				if err != io.EOF {
+					inputErr <- err
+				}
+				close(input)
+				return
+			}
+		}
+	}()
+
+	seq := uint16(newPacketID())
+	queue := make([]*pipePacket, 0)
+	var pending *pipePacket
+	var lastSent time.Time
+	inputOpen := true
+
+	partials := make(map[[2]uint32]*pipeWrite)
+	completed := make(map[[2]uint32]time.Time)
+
+	for {
+		select {
+		case err := <-inputErr:
+			return cli.Exit(err, 0)
+		case data, ok := <-input:
+			if !ok {
+				inputOpen = false
+				input = nil
+				break
+			}
+			if raw {
+				queue = append(queue, &pipePacket{payload: data})
+			} else {
+				for _, chunk := range pipeChunks(seq, data) {
+					queue = append(queue, &pipePacket{payload: chunk})
+				}
+				seq++
+			}
+		default:
+		}
+
+		if pending != nil && time.Since(pending.sent) > c.Duration("ack-timeout") {
+			if pending.tries > c.Int("retries") {
+				return cli.Exit(fmt.Sprintf("No ACK for packet %d after %d tries", pending.id, pending.tries), 0)
+			}
+			queue = append([]*pipePacket{pending}, queue...)
+			pending = nil
+		}
+
+		if pending == nil && len(queue) > 0 && time.Since(lastSent) >= c.Duration("interval") {
+			packet := queue[0]
+			queue = queue[1:]
+			if packet.id == 0 {
+				packet.id = newPacketID()
+			}
+			err := sendPacket(radio, &gomeshproto.MeshPacket{
+				To:      to,
+				Id:      packet.id,
+				Channel: channel,
+				WantAck: c.Bool("ack"),
+				PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+					Decoded: &gomeshproto.Data{
+						Payload: packet.payload,
+						Portnum: portNum,
+					},
+				},
+			})
+			if err != nil {
+				return cli.Exit(err, 0)
+			}
+			packet.sent = time.Now()
+			packet.tries++
+			lastSent = packet.sent
+			if c.Bool("ack") {
+				pending = packet
+			}
+		}
+
+		if !inputOpen && len(queue) == 0 && pending == nil && !c.Bool("listen") {
+			return nil
+		}
+
+		responses, err := radio.ReadResponse(false)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+
+		for _, response := range responses {
+			packet := response.GetPacket()
+			decoded := packet.GetDecoded()
+			if decoded == nil {
+				continue
+			}
+
+			if decoded.Portnum == gomeshproto.PortNum_ROUTING_APP && pending != nil && decoded.RequestId == pending.id {
+				routing := gomeshproto.Routing{}
+				if err := unmarshalPayload(packet, &routing); err != nil {
+					continue
+				}
+				if routing.GetErrorReason() != gomeshproto.Routing_NONE {
+					fmt.Fprintf(os.Stderr, "Packet %d failed: %s\n", pending.id, routing.GetErrorReason())
+					pending.sent = time.Time{}
+					continue
+				}
+				pending = nil
+				continue
+			}
+
+			if decoded.Portnum != portNum || (to != broadcastNum && packet.From != to) {
+				continue
+			}
+
+			if raw {
+				if _, err := os.Stdout.Write(decoded.Payload); err != nil {
+					return cli.Exit(err, 0)
+				}
+				continue
+			}
+
+			data, err := reassemblePipe(partials, completed, packet.From, decoded.Payload)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Dropped packet from %s: %v\n", nodeID(packet.From), err)
+				continue
+			}
+			if data != nil {
+				if _, err := os.Stdout.Write(data); err != nil {
+					return cli.Exit(err, 0)
+				}
+			}
+		}
+	}
+}
+
+// parsePortNum parses a port number name such as SERIAL_APP, or a number
+func parsePortNum(value string) (gomeshproto.PortNum, error) {
+	if num, ok := gomeshproto.PortNum_value[value]; ok {
+		return gomeshproto.PortNum(num), nil
+	}
+
+	num, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown port number %s", value)
+	}
+	return gomeshproto.PortNum(num), nil
+}
+
+// pipeChunks splits a write into packet payloads, each starting with the pipe header
+func pipeChunks(seq uint16, data []byte) [][]byte {
+	size := int(gomeshproto.Constants_DATA_PAYLOAD_LEN) - pipeHeaderLen
+	count := (len(data) + size - 1) / size
+
+	chunks := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+		chunk := make([]byte, pipeHeaderLen, pipeHeaderLen+end-i*size)
+		binary.BigEndian.PutUint16(chunk, seq)
+		chunk[2] = byte(i)
+		chunk[3] = byte(count)
+		chunks = append(chunks, append(chunk, data[i*size:end]...))
+	}
+
+	return chunks
+}
+
+// reassemblePipe adds a received chunk to the write it belongs to, and returns the write once every chunk
+// has arrived. Chunks of writes that were already completed are ignored, since they're retransmissions
+func reassemblePipe(partials map[[2]uint32]*pipeWrite, completed map[[2]uint32]time.Time, from uint32, payload []byte) ([]byte, error) {
+	for key, started := range completed {
+		if time.Since(started) > pipePartialAge {
+			delete(completed, key)
+		}
+	}
+	for key, partial := range partials {
+		if time.Since(partial.started) > pipePartialAge {
+			fmt.Fprintf(os.Stderr, "Gave up on write %d from %s with %d of %d chunks\n", key[1], nodeID(key[0]), partial.received, len(partial.chunks))
+			delete(partials, key)
+		}
+	}
+
+	if len(payload) < pipeHeaderLen {
+		return nil, errors.New("too short for a pipe header")
+	}
+	key := [2]uint32{from, uint32(binary.BigEndian.Uint16(payload))}
+	index := int(payload[2])
+	count := int(payload[3])
+	if count == 0 || index >= count {
+		return nil, fmt.Errorf("invalid chunk %d of %d", index, count)
+	}
+
+	if _, ok := completed[key]; ok {
+		return nil, nil
+	}
+
+	partial, ok := partials[key]
+	if !ok {
+		partial = &pipeWrite{chunks: make([][]byte, count), started: time.Now()}
+		partials[key] = partial
+	}
+	if len(partial.chunks) != count {
+		return nil, fmt.Errorf("chunk count changed from %d to %d", len(partial.chunks), count)
+	}
+	if partial.chunks[index] != nil {
+		return nil, nil
+	}
+	partial.chunks[index] = payload[pipeHeaderLen:]
+	partial.received++
+
+	if partial.received < count {
+		return nil, nil
+	}
+
+	delete(partials, key)
+	completed[key] = partial.started
+
+	data := make([]byte, 0)
+	for _, chunk := range partial.chunks {
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
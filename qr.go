@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"github.com/urfave/cli/v2"
+)
+
+func showChannelQR(c *cli.Context) error {
+	radio := getRadio(c)
+	defer radio.Close()
+
+	channels, err := radio.GetChannels()
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	loraConfig, err := getLoraConfig(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	primaryURL, fullURL, err := channelURLs(channels, loraConfig)
+	if err != nil {
+		return cli.Exit("Error parsing channel URL", 0)
+	}
+
+	url := fullURL
+	if c.Bool("primary") {
+		url = primaryURL
+	}
+
+	qr, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	if c.String("png") != "" {
+		err = qr.WriteFile(c.Int("size"), c.String("png"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		fmt.Printf("QR code written to %s\n", c.String("png"))
+		return nil
+	}
+
+	printQR(qr.Bitmap())
+	fmt.Printf("%s\n", url)
+
+	return nil
+}
+
+// printQR draws a QR bitmap in the terminal. Each character cell holds two rows of modules using the
+// upper half block, with the top module as the foreground color and the bottom module as the background
+func printQR(bitmap [][]bool) {
+	const black = 0
+	const white = 7
+
+	color := func(set bool) int {
+		if set {
+			return black
+		}
+		return white
+	}
+
+	var sb strings.Builder
+	for y := 0; y < len(bitmap); y += 2 {
+		for x := range bitmap[y] {
+			bottom := false
+			if y+1 < len(bitmap) {
+				bottom = bitmap[y+1][x]
+			}
+			fmt.Fprintf(&sb, "\x1b[3%d;4%dm▀", color(bitmap[y][x]), color(bottom))
+		}
+		sb.WriteString("\x1b[0m\n")
+	}
+
+	fmt.Print(sb.String())
+}
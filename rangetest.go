@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// rangeTestHeader is the header row of range test CSV logs
+var rangeTestHeader = []string{"time", "node_id", "name", "seq", "snr", "rssi", "hops", "latitude", "longitude", "altitude", "distance_meters"}
+
+// rangeTestSender is what was received from one node sending range test packets. Loss is counted from
+// the first sequence number received, since earlier packets may have been sent before we started listening
+type rangeTestSender struct {
+	seqs     map[int]bool
+	first    int
+	last     int
+	snrTotal float64
+	maxDist  float64
+}
+
+func sendRangeTest(c *cli.Context) error {
+
+	if c.Duration("interval") <= 0 {
+		return cli.Exit("--interval must be greater than 0", 0)
+	}
+
+	to := uint32(broadcastNum)
+	if c.IsSet("to") {
+		var err error
+		to, err = parseNodeID(c.String("to"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	count := c.Int("count")
+	for seq := 1; count == 0 || seq <= count; seq++ {
+		if seq > 1 {
+			time.Sleep(c.Duration("interval"))
+		}
+
+		err := sendPacket(radio, &gomeshproto.MeshPacket{
+			To:      to,
+			Id:      newPacketID(),
+			Channel: uint32(c.Int64("channel")),
+			PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+				Decoded: &gomeshproto.Data{
+					Payload: []byte(fmt.Sprintf("seq %d", seq)),
+					Portnum: gomeshproto.PortNum_RANGE_TEST_APP,
+				},
+			},
+		})
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		fmt.Printf("%s Sent seq %d\n", time.Now().Format(time.RFC3339), seq)
+	}
+
+	return nil
+}
+
+func receiveRangeTest(c *cli.Context) error {
+
+	var out *csv.Writer
+	if c.String("csv") != "" {
+		f, err := os.Create(c.String("csv"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		defer f.Close()
+		out = csv.NewWriter(f)
+		out.Write(rangeTestHeader)
+		defer out.Flush()
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	responses, err := radio.GetRadioInfo()
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	var nodeNum uint32
+	for _, response := range responses {
+		if info := response.GetMyInfo(); info != nil {
+			nodeNum = info.MyNodeNum
+		}
+	}
+
+	names := make(map[uint32]string)
+	positions := make(map[uint32]*gomeshproto.Position)
+	for _, response := range responses {
+		info := response.GetNodeInfo()
+		if info == nil {
+			continue
+		}
+		if info.User.GetLongName() != "" {
+			names[info.Num] = info.User.LongName
+		}
+		if info.Position.GetLatitudeI() != 0 || info.Position.GetLongitudeI() != 0 {
+			positions[info.Num] = info.Position
+		}
+	}
+
+	// A position given on the command line is used instead of the radio's own, for radios without GPS
+	if c.IsSet("lat") || c.IsSet("long") || c.IsSet("grid") {
+		position, err := positionFromFlags(c)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		positions[nodeNum] = position
+	}
+	if _, ok := positions[nodeNum]; !ok {
+		fmt.Fprintln(os.Stderr, "Our position isn't known, distances won't be calculated")
+	}
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt)
+	defer signal.Stop(interrupted)
+
+	var deadline time.Time
+	if c.Duration("duration") > 0 {
+		deadline = time.Now().Add(c.Duration("duration"))
+	}
+
+	senders := make(map[uint32]*rangeTestSender)
+	handler := func(packet *gomeshproto.MeshPacket) error {
+		switch packet.GetDecoded().GetPortnum() {
+		case gomeshproto.PortNum_NODEINFO_APP:
+			user := gomeshproto.User{}
+			if err := unmarshalPayload(packet, &user); err == nil && user.LongName != "" {
+				names[packet.From] = user.LongName
+			}
+			return nil
+		case gomeshproto.PortNum_POSITION_APP:
+			position := gomeshproto.Position{}
+			if err := unmarshalPayload(packet, &position); err == nil && (position.LatitudeI != 0 || position.LongitudeI != 0) {
+				positions[packet.From] = &position
+			}
+			return nil
+		case gomeshproto.PortNum_RANGE_TEST_APP:
+		default:
+			return nil
+		}
+
+		var seq int
+		if _, err := fmt.Sscanf(string(packet.GetDecoded().Payload), "seq %d", &seq); err != nil {
+			return nil
+		}
+
+		sender, ok := senders[packet.From]
+		if !ok {
+			sender = &rangeTestSender{seqs: make(map[int]bool), first: seq}
+			senders[packet.From] = sender
+		}
+		if seq < sender.first {
+			sender.first = seq
+		}
+		if sender.seqs[seq] {
+			return nil
+		}
+		sender.seqs[seq] = true
+		if seq > sender.last {
+			sender.last = seq
+		}
+		sender.snrTotal += float64(packet.RxSnr)
+
+		name, ok := names[packet.From]
+		if !ok {
+			name = nodeID(packet.From)
+		}
+
+		hops := ""
+		if packet.HopStart > 0 {
+			hops = fmt.Sprint(packet.HopStart - packet.HopLimit)
+		}
+
+		row := []string{time.Now().UTC().Format(time.RFC3339), nodeID(packet.From), name, fmt.Sprint(seq),
+			strconv.FormatFloat(widenFloat(packet.RxSnr), 'f', -1, 64), fmt.Sprint(packet.RxRssi), hops, "", "", "", ""}
+		distance := ""
+		if position, ok := positions[packet.From]; ok {
+			lat := float64(position.LatitudeI) / 1e7
+			lon := float64(position.LongitudeI) / 1e7
+			row[7] = strconv.FormatFloat(lat, 'f', 7, 64)
+			row[8] = strconv.FormatFloat(lon, 'f', 7, 64)
+			row[9] = fmt.Sprint(position.Altitude)
+			if ours, ok := positions[nodeNum]; ok {
+				meters := distanceMeters(float64(ours.LatitudeI)/1e7, float64(ours.LongitudeI)/1e7, lat, lon)
+				sender.maxDist = math.Max(sender.maxDist, meters)
+				row[10] = strconv.FormatFloat(math.Round(meters), 'f', -1, 64)
+				distance = fmt.Sprintf(" %.0fm away", meters)
+			}
+		}
+
+		fmt.Printf("%s seq %d from %s SNR %.2f RSSI %d%s\n", row[0], seq, name, packet.RxSnr, packet.RxRssi, distance)
+
+		if out != nil {
+			out.Write(row)
+			out.Flush()
+			return out.Error()
+		}
+		return nil
+	}
+
+	for deadline.IsZero() || time.Now().Before(deadline) {
+		// Listen in short slices so an interrupt still gets a summary
+		err := listenPackets(radio, time.Now().Add(time.Second), handler)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+
+		select {
+		case <-interrupted:
+			deadline = time.Now()
+		default:
+		}
+	}
+
+	printRangeTestSummary(senders, names)
+
+	return nil
+}
+
+func printRangeTestSummary(senders map[uint32]*rangeTestSender, names map[uint32]string) {
+	nums := make([]uint32, 0, len(senders))
+	for num := range senders {
+		nums = append(nums, num)
+	}
+	sort.Slice(nums, func(i, j int) bool { return nums[i] < nums[j] })
+
+	fmt.Printf("\n")
+	fmt.Printf("Range Test Summary:\n")
+	printDoubleDivider()
+	fmt.Printf("| %-25s| ", "Sender")
+	fmt.Printf("%-10s| ", "Received")
+	fmt.Printf("%-10s| ", "Expected")
+	fmt.Printf("%-10s| ", "Loss")
+	fmt.Printf("%-10s| ", "Avg SNR")
+	fmt.Printf("%-15s", "Max Distance   |\n")
+	printSingleDivider()
+	for _, num := range nums {
+		sender := senders[num]
+		name, ok := names[num]
+		if !ok {
+			name = nodeID(num)
+		}
+		received := len(sender.seqs)
+		expected := sender.last - sender.first + 1
+		maxDist := "-"
+		if sender.maxDist > 0 {
+			maxDist = fmt.Sprintf("%.0fm", sender.maxDist)
+		}
+
+		fmt.Printf("| %-25s| ", name)
+		fmt.Printf("%-10d| ", received)
+		fmt.Printf("%-10d| ", expected)
+		fmt.Printf("%-10s| ", fmt.Sprintf("%.1f%%", 100*float64(expected-received)/float64(expected)))
+		fmt.Printf("%-10.2f| ", sender.snrTotal/float64(received))
+		fmt.Printf("%-15s|\n", maxDist)
+	}
+	printDoubleDivider()
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+//go:embed web
+var webFiles embed.FS
+
+// localTiles is the tile URL template used when map tiles are served from --tiles-dir
+const localTiles = "/tiles/{z}/{x}/{y}.png"
+
+func serve(c *cli.Context) error {
+
+	if c.String("http") == "" && c.String("grpc") == "" {
+		return cli.Exit("--http or --grpc is required", 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	session, err := newMeshSession(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	errs := make(chan error, 3)
+	go func() {
+		errs <- session.run()
+	}()
+
+	if c.String("http") != "" {
+		tiles := c.String("tiles")
+		mux := http.NewServeMux()
+		if c.String("tiles-dir") != "" {
+			mux.Handle("/tiles/", http.StripPrefix("/tiles/", http.FileServer(http.Dir(c.String("tiles-dir")))))
+			tiles = localTiles
+		}
+		if err := addDashboardRoutes(mux, session, tiles); err != nil {
+			return cli.Exit(err, 0)
+		}
+		addAPIRoutes(mux, session)
+
+		go func() {
+			errs <- http.ListenAndServe(c.String("http"), mux)
+		}()
+		fmt.Printf("Serving dashboard and API on %s\n", c.String("http"))
+	}
+
+	if c.String("grpc") != "" {
+		go func() {
+			errs <- serveGRPC(c.String("grpc"), session, c.Bool("grpc-admin"))
+		}()
+		fmt.Printf("Serving gRPC on %s\n", c.String("grpc"))
+	}
+
+	return cli.Exit(<-errs, 0)
+}
+
+// addDashboardRoutes adds the web dashboard and the endpoints it uses to load state and follow events
+func addDashboardRoutes(mux *http.ServeMux, s *meshSession, tiles string) error {
+	files, err := fs.Sub(webFiles, "web")
+	if err != nil {
+		return err
+	}
+	mux.Handle("/", http.FileServer(http.FS(files)))
+
+	mux.HandleFunc("/state", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"node_num": s.nodeNum,
+			"nodes":    s.nodeSummaries(),
+			"links":    s.links(),
+			"messages": s.messagesSince(0),
+			"tiles":    tiles,
+		})
+	})
+
+	mux.HandleFunc("/telemetry", func(w http.ResponseWriter, r *http.Request) {
+		num, err := strconv.ParseUint(r.URL.Query().Get("node"), 10, 32)
+		if err != nil {
+			http.Error(w, "invalid node", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, s.telemetryHistory(uint32(num)))
+	})
+
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		streamEvents(w, r, s)
+	})
+
+	return nil
+}
+
+// streamEvents sends session events to the client as server-sent events until the client disconnects.
+// Every packet from the radio is also sent as a packet event when the packets query parameter is true
+func streamEvents(w http.ResponseWriter, r *http.Request, s *meshSession) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	packets, _ := strconv.ParseBool(r.URL.Query().Get("packets"))
+
+	events := s.subscribe()
+	defer s.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep proxies from closing the connection while the mesh is quiet
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event := <-events:
+			var data []byte
+			var err error
+			if event.Type == "packet" {
+				if !packets {
+					continue
+				}
+				data, err = protojson.Marshal(event.Packet)
+			} else {
+				data, err = json.Marshal(event.Data)
+			}
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+		}
+		flusher.Flush()
+	}
+}
+
+// writeJSON writes a value as the JSON response with the given status. The value is encoded before
+// anything is written so an encoding error can still be sent as a 500 response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	var body bytes.Buffer
+	if err := json.NewEncoder(&body).Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Limits on how much history a session keeps in memory
+const (
+	maxSessionMessages  = 1000
+	maxTelemetryHistory = 500
+	subscriberBuffer    = 64
+)
+
+// meshSession keeps a single radio connection open for long running commands. Work that needs the radio
+// is queued and run between reads, so callers in different goroutines never use the serial stream at the
+// same time. The session also keeps the node database, messages and telemetry up to date from the
+// packets it receives
+type meshSession struct {
+	radio   gomesh.Radio
+	nodeNum uint32
+	jobs    chan sessionJob
+
+	mu          sync.RWMutex
+	nodes       map[uint32]*gomeshproto.NodeInfo
+	channels    map[int32]*gomeshproto.Channel
+	configs     map[string]*gomeshproto.Config
+	modules     map[string]*gomeshproto.ModuleConfig
+	messages    []*sessionMessage
+	messageSeq  uint64
+	telemetry   map[uint32][]telemetryPoint
+	graph       *meshGraph
+	assembler   *messageAssembler
+	subscribers map[chan sessionEvent]struct{}
+}
+
+// sessionJob is work queued for the radio along with where to send its result
+type sessionJob struct {
+	run    func(r gomesh.Radio) error
+	result chan error
+}
+
+// sessionEvent is sent to subscribers when the session receives something from the radio. Packet holds
+// the raw packet for every event, Type and Data describe what changed for events other than "packet"
+type sessionEvent struct {
+	Type   string
+	Data   interface{}
+	Packet *gomeshproto.FromRadio
+}
+
+// sessionMessage is a text message sent or received on the mesh
+type sessionMessage struct {
+	Seq     uint64    `json:"seq"`
+	ID      uint32    `json:"id"`
+	From    uint32    `json:"from"`
+	To      uint32    `json:"to"`
+	Channel uint32    `json:"channel"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	Snr     float32   `json:"snr"`
+	Rssi    int32     `json:"rssi"`
+	ReplyID uint32    `json:"reply_id,omitempty"`
+	Emoji   bool      `json:"emoji,omitempty"`
+}
+
+// telemetryPoint is a device or environment reading from a node
+type telemetryPoint struct {
+	Node               uint32    `json:"node"`
+	Time               time.Time `json:"time"`
+	BatteryLevel       *uint32   `json:"battery_level,omitempty"`
+	Voltage            *float32  `json:"voltage,omitempty"`
+	ChannelUtilization *float32  `json:"channel_utilization,omitempty"`
+	AirUtilTx          *float32  `json:"air_util_tx,omitempty"`
+	Temperature        *float32  `json:"temperature,omitempty"`
+	RelativeHumidity   *float32  `json:"relative_humidity,omitempty"`
+	BarometricPressure *float32  `json:"barometric_pressure,omitempty"`
+}
+
+// newMeshSession loads the radio's current state and creates a session for it
+func newMeshSession(r gomesh.Radio) (*meshSession, error) {
+	s := &meshSession{
+		radio:       r,
+		jobs:        make(chan sessionJob),
+		nodes:       make(map[uint32]*gomeshproto.NodeInfo),
+		channels:    make(map[int32]*gomeshproto.Channel),
+		configs:     make(map[string]*gomeshproto.Config),
+		modules:     make(map[string]*gomeshproto.ModuleConfig),
+		telemetry:   make(map[uint32][]telemetryPoint),
+		graph:       newMeshGraph(),
+		assembler:   newMessageAssembler(),
+		subscribers: make(map[chan sessionEvent]struct{}),
+	}
+
+	responses, err := r.GetRadioInfo()
+	if err != nil {
+		return nil, err
+	}
+	for _, response := range responses {
+		s.handle(response)
+	}
+
+	if s.nodeNum == 0 {
+		return nil, errors.New("failed to get node number")
+	}
+
+	return s, nil
+}
+
+// run reads from the radio and runs queued jobs until reading fails
+func (s *meshSession) run() error {
+	for {
+		select {
+		case job := <-s.jobs:
+			job.result <- job.run(s.radio)
+			continue
+		default:
+		}
+
+		responses, err := s.radio.ReadResponse(false)
+		if err != nil {
+			return err
+		}
+
+		for _, response := range responses {
+			s.handle(response)
+		}
+	}
+}
+
+// do queues work for the radio and waits for it to finish
+func (s *meshSession) do(run func(r gomesh.Radio) error) error {
+	job := sessionJob{run: run, result: make(chan error, 1)}
+	s.jobs <- job
+	return <-job.result
+}
+
+// refresh asks the radio for its node info, node database, channels and config again and updates the
+// session from the response
+func (s *meshSession) refresh() ([]*gomeshproto.FromRadio, error) {
+	var responses []*gomeshproto.FromRadio
+	err := s.do(func(r gomesh.Radio) error {
+		var err error
+		responses, err = r.GetRadioInfo()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, response := range responses {
+		s.handle(response)
+	}
+
+	return responses, nil
+}
+
+// subscribe returns a channel that receives every session event. Events are dropped for subscribers that
+// fall behind rather than blocking the radio
+func (s *meshSession) subscribe() chan sessionEvent {
+	events := make(chan sessionEvent, subscriberBuffer)
+
+	s.mu.Lock()
+	s.subscribers[events] = struct{}{}
+	s.mu.Unlock()
+
+	return events
+}
+
+func (s *meshSession) unsubscribe(events chan sessionEvent) {
+	s.mu.Lock()
+	delete(s.subscribers, events)
+	s.mu.Unlock()
+}
+
+// publish sends an event to every subscriber. It must be called with the lock held
+func (s *meshSession) publish(event sessionEvent) {
+	for events := range s.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// handle updates the session from a packet received from the radio and notifies subscribers
+func (s *meshSession) handle(response *gomeshproto.FromRadio) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch variant := response.GetPayloadVariant().(type) {
+	case *gomeshproto.FromRadio_MyInfo:
+		s.nodeNum = variant.MyInfo.MyNodeNum
+	case *gomeshproto.FromRadio_NodeInfo:
+		s.nodes[variant.NodeInfo.Num] = variant.NodeInfo
+		s.publish(sessionEvent{Type: "node", Data: summarizeNode(variant.NodeInfo), Packet: response})
+	case *gomeshproto.FromRadio_Channel:
+		s.channels[variant.Channel.Index] = variant.Channel
+	case *gomeshproto.FromRadio_Config:
+		s.configs[fmt.Sprintf("%T", variant.Config.PayloadVariant)] = variant.Config
+	case *gomeshproto.FromRadio_ModuleConfig:
+		s.modules[fmt.Sprintf("%T", variant.ModuleConfig.PayloadVariant)] = variant.ModuleConfig
+	case *gomeshproto.FromRadio_Packet:
+		s.handlePacket(variant.Packet, response)
+	}
+
+	s.publish(sessionEvent{Type: "packet", Packet: response})
+}
+
+// handlePacket updates the session from a mesh packet. It must be called with the lock held
+func (s *meshSession) handlePacket(packet *gomeshproto.MeshPacket, response *gomeshproto.FromRadio) {
+	if packet.GetDecoded() == nil {
+		return
+	}
+
+	heard := time.Now()
+	node := s.node(packet.From)
+	node.LastHeard = uint32(heard.Unix())
+	if packet.RxSnr != 0 {
+		node.Snr = packet.RxSnr
+	}
+	if packet.HopStart > 0 {
+		node.HopsAway = packet.HopStart - packet.HopLimit
+	}
+
+	switch packet.GetDecoded().GetPortnum() {
+	case gomeshproto.PortNum_TEXT_MESSAGE_APP:
+		// Parts of long messages are only added once the whole message has arrived
+		for _, received := range append(s.assembler.expire(), s.assembler.add(packet)...) {
+			message := s.addMessage(received.Id, received.From, received.To, received.Channel, string(received.GetDecoded().Payload))
+			message.Snr = received.RxSnr
+			message.Rssi = received.RxRssi
+			message.ReplyID = received.GetDecoded().GetReplyId()
+			message.Emoji = received.GetDecoded().GetEmoji() != 0
+			s.publish(sessionEvent{Type: "message", Data: *message, Packet: response})
+		}
+	case gomeshproto.PortNum_NODEINFO_APP:
+		user := gomeshproto.User{}
+		if err := unmarshalPayload(packet, &user); err == nil {
+			node.User = &user
+		}
+	case gomeshproto.PortNum_POSITION_APP:
+		position := gomeshproto.Position{}
+		if err := unmarshalPayload(packet, &position); err == nil && (position.LatitudeI != 0 || position.LongitudeI != 0) {
+			node.Position = &position
+		}
+	case gomeshproto.PortNum_TELEMETRY_APP:
+		telemetry := gomeshproto.Telemetry{}
+		if err := unmarshalPayload(packet, &telemetry); err == nil {
+			if metrics := telemetry.GetDeviceMetrics(); metrics != nil {
+				node.DeviceMetrics = metrics
+			}
+			point := newTelemetryPoint(packet.From, heard, &telemetry)
+			history := append(s.telemetry[packet.From], point)
+			if len(history) > maxTelemetryHistory {
+				history = history[len(history)-maxTelemetryHistory:]
+			}
+			s.telemetry[packet.From] = history
+			s.publish(sessionEvent{Type: "telemetry", Data: point, Packet: response})
+		}
+	case gomeshproto.PortNum_NEIGHBORINFO_APP, gomeshproto.PortNum_TRACEROUTE_APP:
+		if s.graph.addPacket(packet, heard) {
+			s.publish(sessionEvent{Type: "links", Data: s.graph.sortedLinks(), Packet: response})
+		}
+	}
+
+	s.publish(sessionEvent{Type: "node", Data: summarizeNode(node), Packet: response})
+}
+
+// node returns the node database entry for a node, adding it if the node hasn't been seen before. It
+// must be called with the lock held
+func (s *meshSession) node(num uint32) *gomeshproto.NodeInfo {
+	node, ok := s.nodes[num]
+	if !ok {
+		node = &gomeshproto.NodeInfo{Num: num}
+		s.nodes[num] = node
+	}
+
+	return node
+}
+
+// addMessage adds a text message to the message history. It must be called with the lock held
+func (s *meshSession) addMessage(id uint32, from uint32, to uint32, channel uint32, text string) *sessionMessage {
+	s.messageSeq++
+	message := &sessionMessage{
+		Seq:     s.messageSeq,
+		ID:      id,
+		From:    from,
+		To:      to,
+		Channel: channel,
+		Text:    text,
+		Time:    time.Now(),
+	}
+
+	s.messages = append(s.messages, message)
+	if len(s.messages) > maxSessionMessages {
+		s.messages = s.messages[len(s.messages)-maxSessionMessages:]
+	}
+
+	return message
+}
+
+// nodeSummaries returns a summary of every node in the node database
+func (s *meshSession) nodeSummaries() []nodeSummary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summaries := make([]nodeSummary, 0, len(s.nodes))
+	for _, node := range s.nodes {
+		summaries = append(summaries, summarizeNode(node))
+	}
+
+	return summaries
+}
+
+// channelList returns the radio's channels ordered by index
+func (s *meshSession) channelList() []*gomeshproto.Channel {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	channels := make([]*gomeshproto.Channel, 0, len(s.channels))
+	for _, channel := range s.channels {
+		channels = append(channels, proto.Clone(channel).(*gomeshproto.Channel))
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Index < channels[j].Index })
+
+	return channels
+}
+
+// storeChannel updates the session's copy of a channel after it has been changed on the radio
+func (s *meshSession) storeChannel(channel *gomeshproto.Channel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.channels[channel.Index] = channel
+}
+
+// storeConfig updates the session's copy of a config section after it has been changed on the radio
+func (s *meshSession) storeConfig(config *gomeshproto.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.configs[fmt.Sprintf("%T", config.PayloadVariant)] = config
+}
+
+// radioConfig returns the radio's config and module config sections
+func (s *meshSession) radioConfig() ([]*gomeshproto.Config, []*gomeshproto.ModuleConfig) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	configs := make([]*gomeshproto.Config, 0, len(s.configs))
+	for _, config := range s.configs {
+		configs = append(configs, proto.Clone(config).(*gomeshproto.Config))
+	}
+	modules := make([]*gomeshproto.ModuleConfig, 0, len(s.modules))
+	for _, module := range s.modules {
+		modules = append(modules, proto.Clone(module).(*gomeshproto.ModuleConfig))
+	}
+
+	return configs, modules
+}
+
+// sendText sends a text message through the radio and adds it to the message history. Long messages are
+// split into parts, waiting for each part to be acknowledged before sending the next. A reply ID makes the
+// message a reply to that message
+func (s *meshSession) sendText(text string, to uint32, channel uint32, replyID uint32) (sessionMessage, error) {
+	parts := splitText(text, textBudget(replyID, 0, false))
+
+	// Subscribe before sending so the acknowledgement can't be missed
+	events := s.subscribe()
+	defer s.unsubscribe(events)
+
+	var id uint32
+	for i, part := range parts {
+		var partID uint32
+		err := s.do(func(r gomesh.Radio) error {
+			var err error
+			partID, err = sendTextMessage(r, part, to, channel, replyID, 0, nil)
+			return err
+		})
+		if err != nil {
+			return sessionMessage{}, err
+		}
+		if i == 0 {
+			id = partID
+		}
+
+		if i < len(parts)-1 {
+			if err := s.waitForAck(events, partID, partAckTimeout); err != nil {
+				return sessionMessage{}, fmt.Errorf("part %d of %d: %v", i+1, len(parts), err)
+			}
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.addMessage(id, s.nodeNum, to, channel, text)
+	message.ReplyID = replyID
+	s.publish(sessionEvent{Type: "message", Data: *message})
+
+	return *message, nil
+}
+
+// waitForAck waits for a routing packet acknowledging a packet the session sent
+func (s *meshSession) waitForAck(events chan sessionEvent, id uint32, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case event := <-events:
+			packet := event.Packet.GetPacket()
+			if event.Type != "packet" || packet.GetDecoded().GetPortnum() != gomeshproto.PortNum_ROUTING_APP || packet.GetDecoded().GetRequestId() != id {
+				continue
+			}
+			acked := false
+			if err := routingResult(packet, &acked); !acked {
+				return err
+			}
+			return nil
+		case <-timer.C:
+			return fmt.Errorf("no acknowledgement after %s", timeout)
+		}
+	}
+}
+
+// links returns every link between nodes the session has seen
+func (s *meshSession) links() []*meshLink {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.graph.sortedLinks()
+}
+
+// messagesSince returns the messages with a sequence number after since
+func (s *meshSession) messagesSince(since uint64) []sessionMessage {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	messages := make([]sessionMessage, 0)
+	for _, message := range s.messages {
+		if message.Seq > since {
+			messages = append(messages, *message)
+		}
+	}
+
+	return messages
+}
+
+// telemetryHistory returns the telemetry readings received from a node
+func (s *meshSession) telemetryHistory(num uint32) []telemetryPoint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]telemetryPoint(nil), s.telemetry[num]...)
+}
+
+// nodeSummary is the information about a node shown by the dashboard
+type nodeSummary struct {
+	Num       uint32   `json:"num"`
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	ShortName string   `json:"short_name"`
+	Hardware  string   `json:"hardware"`
+	Lat       *float64 `json:"lat,omitempty"`
+	Lon       *float64 `json:"lon,omitempty"`
+	Alt       int32    `json:"alt"`
+	Battery   *uint32  `json:"battery,omitempty"`
+	Voltage   *float32 `json:"voltage,omitempty"`
+	Snr       float32  `json:"snr"`
+	HopsAway  uint32   `json:"hops_away"`
+	LastHeard int64    `json:"last_heard"`
+}
+
+func summarizeNode(node *gomeshproto.NodeInfo) nodeSummary {
+	summary := nodeSummary{
+		Num:       node.Num,
+		ID:        nodeID(node.Num),
+		Name:      nodeID(node.Num),
+		Snr:       node.Snr,
+		HopsAway:  node.HopsAway,
+		LastHeard: int64(node.LastHeard),
+	}
+
+	if node.User != nil {
+		summary.Name = node.User.LongName
+		summary.ShortName = node.User.ShortName
+		summary.Hardware = node.User.HwModel.String()
+	}
+	if node.Position != nil && (node.Position.LatitudeI != 0 || node.Position.LongitudeI != 0) {
+		lat := float64(node.Position.LatitudeI) / 1e7
+		lon := float64(node.Position.LongitudeI) / 1e7
+		summary.Lat, summary.Lon = &lat, &lon
+		summary.Alt = node.Position.Altitude
+	}
+	if node.DeviceMetrics != nil {
+		battery, voltage := node.DeviceMetrics.BatteryLevel, node.DeviceMetrics.Voltage
+		summary.Battery, summary.Voltage = &battery, &voltage
+	}
+
+	return summary
+}
+
+func newTelemetryPoint(num uint32, heard time.Time, telemetry *gomeshproto.Telemetry) telemetryPoint {
+	point := telemetryPoint{Node: num, Time: heard}
+	if telemetry.Time > 0 {
+		point.Time = time.Unix(int64(telemetry.Time), 0)
+	}
+
+	if device := telemetry.GetDeviceMetrics(); device != nil {
+		point.BatteryLevel = proto.Uint32(device.BatteryLevel)
+		point.Voltage = proto.Float32(device.Voltage)
+		point.ChannelUtilization = proto.Float32(device.ChannelUtilization)
+		point.AirUtilTx = proto.Float32(device.AirUtilTx)
+	}
+	if environment := telemetry.GetEnvironmentMetrics(); environment != nil {
+		point.Temperature = proto.Float32(environment.Temperature)
+		point.RelativeHumidity = proto.Float32(environment.RelativeHumidity)
+		point.BarometricPressure = proto.Float32(environment.BarometricPressure)
+	}
+
+	return point
+}
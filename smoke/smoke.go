@@ -14,11 +14,16 @@ var app string = "../meshtastic-go"
 var port string = "/dev/cu.usbserial-0200674E"
 var sleep_time time.Duration = (2 * time.Second)
 
-func run_and_search(args []string, search string) {
+func run(args []string) string {
 	out, err := exec.Command(app, args...).Output()
 	if err != nil {
 		log.Fatal(err)
 	}
+	return string(out)
+}
+
+func run_and_search(args []string, search string) {
+	out := run(args)
 	if search != "" {
 		if !strings.Contains(string(out), search) {
 			fmt.Printf("Did not find %s\n", search)
@@ -60,6 +65,32 @@ func smoke_prefs_set() {
 	run_and_search(args, "Address                                 foo")
 }
 
+// find_channel_url returns the full channel URL printed by the channel command
+func find_channel_url() string {
+	out := run([]string{"--port", port, "channel"})
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "Full Channel URL:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "Full Channel URL:"))
+		}
+	}
+	fmt.Println("Did not find Full Channel URL")
+	return ""
+}
+
+// smoke_channel_url sets the channels using the radio's own URL and checks the URL doesn't change
+func smoke_channel_url() {
+	url := find_channel_url()
+	if url == "" {
+		return
+	}
+	args := []string{"--port", port, "channel", "url", "-u", url}
+	run_and_search(args, "")
+	time.Sleep(sleep_time)
+	if roundTrip := find_channel_url(); roundTrip != url {
+		fmt.Printf("Channel URL changed after round trip: %s != %s\n", roundTrip, url)
+	}
+}
+
 func main() {
 	smoke_info_r()
 	smoke_info_c()
@@ -67,4 +98,5 @@ func main() {
 	smoke_info_config()
 	smoke_message_send()
 	smoke_prefs_set()
+	smoke_channel_url()
 }
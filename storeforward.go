@@ -0,0 +1,376 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// seenMessageAge is how long packet IDs are remembered for de-duplicating messages
+const seenMessageAge = 30 * 24 * time.Hour
+
+// historyMessage is a text message replayed by a store and forward server
+type historyMessage struct {
+	ID      uint32    `json:"id"`
+	From    uint32    `json:"from"`
+	To      uint32    `json:"to"`
+	Channel uint32    `json:"channel"`
+	Text    string    `json:"text"`
+	Time    time.Time `json:"time"`
+	ReplyID uint32    `json:"reply_id,omitempty"`
+	Emoji   bool      `json:"emoji,omitempty"`
+}
+
+func fetchHistory(c *cli.Context) error {
+
+	server, err := parseNodeID(c.String("server"))
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	if c.Duration("window") < time.Minute {
+		return cli.Exit("--window must be at least 1m", 0)
+	}
+
+	seenPath, err := dataPath("seen-messages.json")
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	seen, err := loadSeenMessages(seenPath)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	// Replayed text messages are sent directly to us, which tells them apart from live traffic
+	nodeNum, err := getNodeNum(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	channel := uint32(c.Int64("channel"))
+	err = sendStoreForward(radio, server, channel, &gomeshproto.StoreAndForward{Rr: gomeshproto.StoreAndForward_CLIENT_STATS})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	err = sendStoreForward(radio, server, channel, &gomeshproto.StoreAndForward{
+		Rr: gomeshproto.StoreAndForward_CLIENT_HISTORY,
+		Variant: &gomeshproto.StoreAndForward_History_{
+			History: &gomeshproto.StoreAndForward_History{Window: uint32(c.Duration("window") / time.Minute)},
+		},
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Fprintf(os.Stderr, "Requested the last %s of messages from %s\n", c.Duration("window"), nodeID(server))
+
+	expected := -1
+	replayed := 0
+	messages := make([]historyMessage, 0)
+	lastActivity := time.Now()
+
+	handler := func(packet *gomeshproto.MeshPacket) error {
+		var message historyMessage
+		switch packet.GetDecoded().GetPortnum() {
+		case gomeshproto.PortNum_TEXT_MESSAGE_APP:
+			// Older servers replay messages as the original text message packets
+			if packet.To != nodeNum {
+				return nil
+			}
+			message = historyMessage{
+				Text:    string(packet.GetDecoded().Payload),
+				ReplyID: packet.GetDecoded().ReplyId,
+				Emoji:   packet.GetDecoded().Emoji != 0,
+			}
+		case gomeshproto.PortNum_STORE_FORWARD_APP:
+			storeForward := gomeshproto.StoreAndForward{}
+			if err := unmarshalPayload(packet, &storeForward); err != nil {
+				return nil
+			}
+
+			switch storeForward.Rr {
+			case gomeshproto.StoreAndForward_ROUTER_TEXT_DIRECT, gomeshproto.StoreAndForward_ROUTER_TEXT_BROADCAST:
+				// Replays keep the original sender in from, and are sent directly to us
+				if packet.To != nodeNum {
+					return nil
+				}
+				message = historyMessage{
+					Text:    string(storeForward.GetText()),
+					ReplyID: packet.GetDecoded().ReplyId,
+					Emoji:   packet.GetDecoded().Emoji != 0,
+				}
+			default:
+				if packet.From != server {
+					return nil
+				}
+				lastActivity = time.Now()
+				if err := printStoreForward(&storeForward); err != nil {
+					return err
+				}
+				if storeForward.Rr == gomeshproto.StoreAndForward_ROUTER_HISTORY {
+					expected = int(storeForward.GetHistory().GetHistoryMessages())
+				}
+				if expected >= 0 && replayed >= expected {
+					return errStopListening
+				}
+				return nil
+			}
+		default:
+			return nil
+		}
+
+		lastActivity = time.Now()
+		replayed++
+
+		message.ID = packet.Id
+		message.From = packet.From
+		message.To = packet.To
+		message.Channel = packet.Channel
+		message.Time = time.Now()
+		if packet.RxTime > 0 {
+			message.Time = time.Unix(int64(packet.RxTime), 0)
+		}
+
+		if _, ok := seen[message.ID]; ok && message.ID != 0 {
+			return nil
+		}
+		seen[message.ID] = time.Now().Unix()
+		messages = append(messages, message)
+
+		if expected >= 0 && replayed >= expected {
+			return errStopListening
+		}
+		return nil
+	}
+
+	// Servers send history slowly to leave airtime for other traffic, so keep listening until the server
+	// has gone quiet rather than for a fixed time
+	for time.Since(lastActivity) < c.Duration("timeout") && (expected < 0 || replayed < expected) {
+		err := listenPackets(radio, time.Now().Add(time.Second), handler)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	if expected < 0 {
+		fmt.Fprintf(os.Stderr, "No history response from %s, check the store and forward module is enabled on it\n", nodeID(server))
+	} else if replayed < expected {
+		fmt.Fprintf(os.Stderr, "Received %d of %d messages before the server went quiet\n", replayed, expected)
+	}
+
+	if err := saveSeenMessages(seenPath, seen); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	if c.Bool("json") {
+		for _, message := range messages {
+			out, err := json.Marshal(message)
+			if err != nil {
+				return cli.Exit(err, 0)
+			}
+			fmt.Println(string(out))
+		}
+		return nil
+	}
+
+	printHistoryMessages(messages)
+	fmt.Printf("%d new messages, %d already seen\n", len(messages), replayed-len(messages))
+
+	return nil
+}
+
+// sendStoreForward sends a store and forward request to a server
+func sendStoreForward(r gomesh.Radio, server uint32, channel uint32, request *gomeshproto.StoreAndForward) error {
+	payload, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	return sendPacket(r, &gomeshproto.MeshPacket{
+		To:      server,
+		Id:      newPacketID(),
+		Channel: channel,
+		WantAck: true,
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Payload: payload,
+				Portnum: gomeshproto.PortNum_STORE_FORWARD_APP,
+			},
+		},
+	})
+}
+
+// printStoreForward prints a heartbeat, statistics or status response from a store and forward server.
+// An error is returned if the server can't handle the request
+func printStoreForward(storeForward *gomeshproto.StoreAndForward) error {
+	switch storeForward.Rr {
+	case gomeshproto.StoreAndForward_ROUTER_HEARTBEAT:
+		heartbeat := storeForward.GetHeartbeat()
+		role := "primary"
+		if heartbeat.GetSecondary() != 0 {
+			role = "secondary"
+		}
+		fmt.Fprintf(os.Stderr, "Heartbeat from %s server, sent every %ds\n", role, heartbeat.GetPeriod())
+	case gomeshproto.StoreAndForward_ROUTER_STATS:
+		stats := storeForward.GetStats()
+		fmt.Fprintf(os.Stderr, "Server stats: %d of %d messages saved (%d total), up %s, %d requests (%d for history), returns up to %d messages from the last %d minutes\n",
+			stats.GetMessagesSaved(), stats.GetMessagesMax(), stats.GetMessagesTotal(),
+			time.Duration(stats.GetUpTime())*time.Second, stats.GetRequests(), stats.GetRequestsHistory(),
+			stats.GetReturnMax(), stats.GetReturnWindow())
+	case gomeshproto.StoreAndForward_ROUTER_HISTORY:
+		history := storeForward.GetHistory()
+		fmt.Fprintf(os.Stderr, "Server is sending %d messages from the last %d minutes\n", history.GetHistoryMessages(), history.GetWindow())
+	case gomeshproto.StoreAndForward_ROUTER_BUSY:
+		return errors.New("store and forward server is busy, try again later")
+	case gomeshproto.StoreAndForward_ROUTER_ERROR:
+		return errors.New("store and forward server returned an error")
+	}
+
+	return nil
+}
+
+// printHistoryMessages prints replayed messages with replies indented under the message they reply to,
+// and reactions counted next to it
+func printHistoryMessages(messages []historyMessage) {
+	ids := make(map[uint32]bool)
+	for _, message := range messages {
+		ids[message.ID] = true
+	}
+
+	top := make([]historyMessage, 0)
+	replies := make(map[uint32][]historyMessage)
+	reactions := make(map[uint32][]string)
+	for _, message := range messages {
+		switch {
+		case message.ReplyID == 0 || !ids[message.ReplyID]:
+			top = append(top, message)
+		case message.Emoji:
+			reactions[message.ReplyID] = append(reactions[message.ReplyID], message.Text)
+		default:
+			replies[message.ReplyID] = append(replies[message.ReplyID], message)
+		}
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Message History:\n")
+	printDoubleDivider()
+	fmt.Printf("| %-20s| ", "Time")
+	fmt.Printf("%-12s| ", "ID")
+	fmt.Printf("%-12s| ", "From")
+	fmt.Printf("%-12s| ", "To")
+	fmt.Printf("%-8s| ", "Channel")
+	fmt.Printf("%-53s|\n", "Message")
+	printSingleDivider()
+	var printThread func(message historyMessage, depth int)
+	printThread = func(message historyMessage, depth int) {
+		to := nodeID(message.To)
+		if message.To == broadcastNum {
+			to = "all"
+		}
+
+		text := fmt.Sprintf("%q", message.Text)
+		if message.ReplyID != 0 && !ids[message.ReplyID] {
+			// The message being replied to is older than the history fetched
+			text = fmt.Sprintf("%q", threadText(&gomeshproto.Data{
+				Payload: []byte(message.Text),
+				ReplyId: message.ReplyID,
+				Emoji:   emojiFlag(message.Emoji),
+			}))
+		}
+		if depth > 0 {
+			text = strings.Repeat("  ", depth-1) + "↳ " + text
+		}
+		if counts := countReactions(reactions[message.ID]); counts != "" {
+			text += " " + counts
+		}
+
+		fmt.Printf("| %-20s| ", message.Time.Format("2006-01-02 15:04:05"))
+		fmt.Printf("%-12d| ", message.ID)
+		fmt.Printf("%-12s| ", nodeID(message.From))
+		fmt.Printf("%-12s| ", to)
+		fmt.Printf("%-8d| ", message.Channel)
+		fmt.Printf("%-53s", text)
+		fmt.Printf("%s", "|\n")
+
+		for _, reply := range replies[message.ID] {
+			printThread(reply, depth+1)
+		}
+	}
+	for _, message := range top {
+		printThread(message, 0)
+	}
+	printDoubleDivider()
+}
+
+// countReactions summarises reactions as each emoji with the number of times it was sent, in the order
+// they were first sent
+func countReactions(emojis []string) string {
+	counts := make(map[string]int)
+	order := make([]string, 0)
+	for _, emoji := range emojis {
+		if counts[emoji] == 0 {
+			order = append(order, emoji)
+		}
+		counts[emoji]++
+	}
+
+	summary := make([]string, 0, len(order))
+	for _, emoji := range order {
+		summary = append(summary, fmt.Sprintf("%s %d", emoji, counts[emoji]))
+	}
+	return strings.Join(summary, " ")
+}
+
+// emojiFlag converts whether a message is a reaction to the value of the emoji field in Data
+func emojiFlag(emoji bool) uint32 {
+	if emoji {
+		return 1
+	}
+	return 0
+}
+
+// loadSeenMessages reads the packet IDs of messages already seen, with the time each was seen
+func loadSeenMessages(path string) (map[uint32]int64, error) {
+	seen := make(map[uint32]int64)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return seen, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &seen); err != nil {
+		return nil, fmt.Errorf("invalid seen messages file %s: %v", path, err)
+	}
+
+	return seen, nil
+}
+
+// saveSeenMessages writes the packet IDs of messages already seen, forgetting any seen too long ago to be
+// replayed again
+func saveSeenMessages(path string, seen map[uint32]int64) error {
+	oldest := time.Now().Add(-seenMessageAge).Unix()
+	for id, when := range seen {
+		if when < oldest || id == 0 {
+			delete(seen, id)
+		}
+	}
+
+	data, err := json.Marshal(seen)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+)
+
+// csvLogHeader is the header row of telemetry CSV logs
+var csvLogHeader = []string{"time", "node_id", "node_num", "name", "type", "metric", "value"}
+
+func logMetrics(c *cli.Context) error {
+
+	if c.String("out") == "" && c.String("influx") == "" {
+		return cli.Exit("--out or --influx is required", 0)
+	}
+
+	var logFile *rotatingFile
+	if c.String("out") != "" {
+		logFile = &rotatingFile{
+			path:    c.String("out"),
+			maxSize: c.Int64("max-size") * 1024 * 1024,
+			daily:   c.Bool("daily"),
+			header:  csvLogHeader,
+		}
+		defer logFile.Close()
+	}
+
+	var influx *influxWriter
+	if c.String("influx") != "" {
+		var err error
+		influx, err = newInfluxWriter(c.String("influx"), c.String("influx-db"), c.String("influx-org"),
+			c.String("influx-bucket"), c.String("influx-token"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	responses, err := radio.GetRadioInfo()
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	names := make(map[uint32]string)
+	for _, response := range responses {
+		if info := response.GetNodeInfo(); info.GetUser().GetLongName() != "" {
+			names[info.Num] = info.User.LongName
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "Logging telemetry and positions until cancelled")
+
+	err = listenPackets(radio, time.Time{}, func(packet *gomeshproto.MeshPacket) error {
+		heard := time.Now().Truncate(time.Second)
+		var readings []telemetryReading
+		var measured time.Time
+
+		switch packet.GetDecoded().GetPortnum() {
+		case gomeshproto.PortNum_NODEINFO_APP:
+			user := gomeshproto.User{}
+			if err := unmarshalPayload(packet, &user); err == nil && user.LongName != "" {
+				names[packet.From] = user.LongName
+			}
+			return nil
+		case gomeshproto.PortNum_TELEMETRY_APP:
+			telemetry := gomeshproto.Telemetry{}
+			if err := unmarshalPayload(packet, &telemetry); err != nil {
+				return nil
+			}
+			readings = telemetryReadings(&telemetry)
+			measured = heard
+			if telemetry.Time > 0 {
+				measured = time.Unix(int64(telemetry.Time), 0)
+			}
+		case gomeshproto.PortNum_POSITION_APP:
+			position := gomeshproto.Position{}
+			if err := unmarshalPayload(packet, &position); err != nil {
+				return nil
+			}
+			readings = positionReadings(&position)
+			measured = heard
+			if position.Time > 0 {
+				measured = time.Unix(int64(position.Time), 0)
+			}
+		default:
+			return nil
+		}
+
+		if len(readings) == 0 {
+			return nil
+		}
+
+		name, ok := names[packet.From]
+		if !ok {
+			name = nodeID(packet.From)
+		}
+
+		if logFile != nil {
+			if err := logFile.writeReadings(measured, packet.From, name, readings); err != nil {
+				return err
+			}
+		}
+		if influx != nil {
+			// A database that's down for a while shouldn't stop the CSV log or the next points
+			if err := influx.write(measured, packet.From, name, readings); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write to InfluxDB: %v\n", err)
+			}
+		}
+
+		fmt.Fprintf(os.Stderr, "%s Logged %d %s readings from %s\n", heard.Format(time.RFC3339), len(readings), readings[0].Kind, name)
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+// positionReadings returns the values in a position packet as readings
+func positionReadings(position *gomeshproto.Position) []telemetryReading {
+	if position.LatitudeI == 0 && position.LongitudeI == 0 {
+		return nil
+	}
+
+	readings := []telemetryReading{
+		{Kind: "position", Name: "latitude_degrees", Value: float64(position.LatitudeI) / 1e7},
+		{Kind: "position", Name: "longitude_degrees", Value: float64(position.LongitudeI) / 1e7},
+		{Kind: "position", Name: "altitude_meters", Value: float64(position.Altitude)},
+	}
+	if position.SatsInView > 0 {
+		readings = append(readings, telemetryReading{Kind: "position", Name: "sats_in_view", Value: float64(position.SatsInView)})
+	}
+	if position.GroundSpeed > 0 {
+		readings = append(readings, telemetryReading{Kind: "position", Name: "ground_speed_mps", Value: float64(position.GroundSpeed)})
+	}
+
+	return readings
+}
+
+// rotatingFile is a CSV log that's moved aside and started again once it reaches a maximum size or, if
+// daily is set, when the day changes. Rotated files are named after the time they were started
+type rotatingFile struct {
+	path    string
+	maxSize int64
+	daily   bool
+	header  []string
+
+	file    *os.File
+	size    int64
+	started time.Time
+}
+
+// writeReadings writes a row for each reading
+func (f *rotatingFile) writeReadings(measured time.Time, num uint32, name string, readings []telemetryReading) error {
+	rows := make([][]string, 0, len(readings))
+	for _, reading := range readings {
+		rows = append(rows, []string{
+			measured.UTC().Format(time.RFC3339),
+			nodeID(num),
+			fmt.Sprint(num),
+			name,
+			reading.Kind,
+			reading.Name,
+			strconv.FormatFloat(reading.Value, 'f', -1, 64),
+		})
+	}
+
+	return f.writeRows(rows)
+}
+
+// writeRows appends rows to the file, rotating it first if needed
+func (f *rotatingFile) writeRows(rows [][]string) error {
+	if err := f.rotate(); err != nil {
+		return err
+	}
+
+	buffer := bytes.Buffer{}
+	w := csv.NewWriter(&buffer)
+	if f.size == 0 {
+		w.Write(f.header)
+	}
+	w.WriteAll(rows)
+	if err := w.Error(); err != nil {
+		return err
+	}
+
+	n, err := f.file.Write(buffer.Bytes())
+	f.size += int64(n)
+	return err
+}
+
+// rotate opens the log file, moving the current one aside first if it's due to be rotated
+func (f *rotatingFile) rotate() error {
+	now := time.Now()
+	if f.file != nil {
+		full := f.maxSize > 0 && f.size >= f.maxSize
+		newDay := f.daily && now.Format("2006-01-02") != f.started.Format("2006-01-02")
+		if !full && !newDay {
+			return nil
+		}
+
+		f.file.Close()
+		f.file = nil
+		ext := filepath.Ext(f.path)
+		rotated := strings.TrimSuffix(f.path, ext) + "-" + f.started.Format("2006-01-02T150405") + ext
+		for i := 1; fileExists(rotated); i++ {
+			rotated = fmt.Sprintf("%s-%s-%d%s", strings.TrimSuffix(f.path, ext), f.started.Format("2006-01-02T150405"), i, ext)
+		}
+		if err := os.Rename(f.path, rotated); err != nil {
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	f.started = now
+	if f.size > 0 {
+		f.started = info.ModTime()
+	}
+
+	return nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (f *rotatingFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// influxWriter writes readings to InfluxDB in line protocol. InfluxDB 2 is used when a bucket is given,
+// otherwise points are written to an InfluxDB 1 database
+type influxWriter struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func newInfluxWriter(server string, database string, org string, bucket string, token string) (*influxWriter, error) {
+	base, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{"precision": {"s"}}
+	if bucket != "" {
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/api/v2/write"
+		query.Set("bucket", bucket)
+		query.Set("org", org)
+	} else {
+		base.Path = strings.TrimSuffix(base.Path, "/") + "/write"
+		query.Set("db", database)
+	}
+	base.RawQuery = query.Encode()
+
+	return &influxWriter{url: base.String(), token: token, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// write sends the readings as a single point tagged with the node and the type of reading
+func (w *influxWriter) write(measured time.Time, num uint32, name string, readings []telemetryReading) error {
+	fields := make([]string, 0, len(readings))
+	for _, reading := range readings {
+		fields = append(fields, escapeInflux(reading.Name)+"="+strconv.FormatFloat(reading.Value, 'f', -1, 64))
+	}
+	line := fmt.Sprintf("meshtastic,node=%s,name=%s,type=%s %s %d\n", nodeID(num), escapeInflux(name),
+		escapeInflux(readings[0].Kind), strings.Join(fields, ","), measured.Unix())
+
+	request, err := http.NewRequest(http.MethodPost, w.url, strings.NewReader(line))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.token != "" {
+		request.Header.Set("Authorization", "Token "+w.token)
+	}
+
+	response, err := w.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("InfluxDB responded with %s", response.Status)
+	}
+
+	return nil
+}
+
+// escapeInflux escapes a tag value or field key for the line protocol. Empty tag values aren't allowed
+func escapeInflux(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `, "\n", `\ `).Replace(value)
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// meshLink is a radio link between two nodes. Links from neighbor info reports carry the SNR the node
+// measured for its neighbor, links from traceroutes only show that the two nodes can hear each other
+type meshLink struct {
+	From   uint32    `json:"from"`
+	To     uint32    `json:"to"`
+	Snr    float32   `json:"snr"`
+	HasSnr bool      `json:"has_snr"`
+	Source string    `json:"source"`
+	Heard  time.Time `json:"heard"`
+}
+
+// meshGraph collects the links between nodes on the mesh
+type meshGraph struct {
+	links map[[2]uint32]*meshLink
+}
+
+func newMeshGraph() *meshGraph {
+	return &meshGraph{links: make(map[[2]uint32]*meshLink)}
+}
+
+// addNeighborInfo adds a link from the reporting node to each of its neighbors
+func (g *meshGraph) addNeighborInfo(info *gomeshproto.NeighborInfo, heard time.Time) {
+	for _, neighbor := range info.Neighbors {
+		g.links[[2]uint32{info.NodeId, neighbor.NodeId}] = &meshLink{
+			From:   info.NodeId,
+			To:     neighbor.NodeId,
+			Snr:    neighbor.Snr,
+			HasSnr: true,
+			Source: "neighborinfo",
+			Heard:  heard,
+		}
+	}
+}
+
+// addRoute adds a link between each pair of nodes along a traceroute. Links that already have an SNR from
+// a neighbor info report are only marked as heard
+func (g *meshGraph) addRoute(route []uint32, heard time.Time) {
+	for i := 1; i < len(route); i++ {
+		key := [2]uint32{route[i-1], route[i]}
+		if link, ok := g.links[key]; ok && link.HasSnr {
+			link.Heard = heard
+			continue
+		}
+		g.links[key] = &meshLink{
+			From:   route[i-1],
+			To:     route[i],
+			Source: "traceroute",
+			Heard:  heard,
+		}
+	}
+}
+
+// addPacket updates the graph from a NEIGHBORINFO_APP or TRACEROUTE_APP packet. It returns false if the
+// packet didn't contain any topology information
+func (g *meshGraph) addPacket(packet *gomeshproto.MeshPacket, heard time.Time) bool {
+	switch packet.GetDecoded().GetPortnum() {
+	case gomeshproto.PortNum_NEIGHBORINFO_APP:
+		info := gomeshproto.NeighborInfo{}
+		if err := unmarshalPayload(packet, &info); err != nil {
+			return false
+		}
+		if info.NodeId == 0 {
+			info.NodeId = packet.From
+		}
+		g.addNeighborInfo(&info, heard)
+		return true
+	case gomeshproto.PortNum_TRACEROUTE_APP:
+		// Only replies hold the complete route, from the node that asked for the route to the destination
+		if packet.GetDecoded().RequestId == 0 {
+			return false
+		}
+		discovery := gomeshproto.RouteDiscovery{}
+		if err := unmarshalPayload(packet, &discovery); err != nil {
+			return false
+		}
+		route := append([]uint32{packet.To}, discovery.Route...)
+		g.addRoute(append(route, packet.From), heard)
+		return true
+	}
+
+	return false
+}
+
+// sortedLinks returns every link ordered by the node numbers
+func (g *meshGraph) sortedLinks() []*meshLink {
+	links := make([]*meshLink, 0, len(g.links))
+	for _, link := range g.links {
+		copied := *link
+		links = append(links, &copied)
+	}
+
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].From != links[j].From {
+			return links[i].From < links[j].From
+		}
+		return links[i].To < links[j].To
+	})
+
+	return links
+}
+
+func showTopology(c *cli.Context) error {
+
+	var write func(w io.Writer, g *meshGraph, names map[uint32]string) error
+	switch c.String("format") {
+	case "table":
+		write = writeAdjacencyTable
+	case "dot":
+		write = writeDOT
+	case "graphml":
+		write = writeGraphML
+	default:
+		return cli.Exit("Format must be table, dot or graphml", 0)
+	}
+
+	targets := make([]uint32, 0)
+	for _, value := range c.StringSlice("traceroute") {
+		num, err := parseNodeID(value)
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		targets = append(targets, num)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	responses, err := radio.GetRadioInfo()
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	graph := newMeshGraph()
+	names := make(map[uint32]string)
+	var nodeNum uint32
+	for _, response := range responses {
+		if info := response.GetMyInfo(); info != nil {
+			nodeNum = info.MyNodeNum
+		}
+	}
+	for _, response := range responses {
+		info := response.GetNodeInfo()
+		if info == nil {
+			continue
+		}
+		names[info.Num] = nodeID(info.Num)
+		if info.User.GetLongName() != "" {
+			names[info.Num] = info.User.LongName
+		}
+		// Nodes in the node database heard directly carry the SNR our radio measured for them
+		if info.Num != nodeNum && info.HopsAway == 0 && info.Snr != 0 && info.LastHeard > 0 {
+			graph.addDirect(nodeNum, info.Num, info.Snr, time.Unix(int64(info.LastHeard), 0))
+		}
+	}
+
+	for _, target := range targets {
+		if err := sendTraceroute(radio, target, uint32(c.Int64("channel"))); err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Collecting neighbor reports and traceroutes for %s\n", c.Duration("listen"))
+	err = listenPackets(radio, time.Now().Add(c.Duration("listen")), func(packet *gomeshproto.MeshPacket) error {
+		if packet.GetDecoded().GetPortnum() == gomeshproto.PortNum_NODEINFO_APP {
+			user := gomeshproto.User{}
+			if err := unmarshalPayload(packet, &user); err == nil && user.LongName != "" {
+				names[packet.From] = user.LongName
+			}
+			return nil
+		}
+		graph.addPacket(packet, time.Now())
+		return nil
+	})
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	out := io.Writer(os.Stdout)
+	if c.String("out") != "" {
+		f, err := os.Create(c.String("out"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := write(out, graph, names); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+// sendTraceroute asks the mesh for the route to a node. The reply is handled by meshGraph.addPacket
+func sendTraceroute(r gomesh.Radio, to uint32, channel uint32) error {
+	payload, err := proto.Marshal(&gomeshproto.RouteDiscovery{})
+	if err != nil {
+		return err
+	}
+
+	return sendPacket(r, &gomeshproto.MeshPacket{
+		To:      to,
+		Id:      newPacketID(),
+		Channel: channel,
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Payload:      payload,
+				Portnum:      gomeshproto.PortNum_TRACEROUTE_APP,
+				WantResponse: true,
+			},
+		},
+	})
+}
+
+// addDirect adds a link to a node the reporting node heard directly. Links from neighbor info reports
+// are kept since they're more recent than the node database
+func (g *meshGraph) addDirect(from uint32, to uint32, snr float32, heard time.Time) {
+	key := [2]uint32{from, to}
+	if _, ok := g.links[key]; ok {
+		return
+	}
+	g.links[key] = &meshLink{From: from, To: to, Snr: snr, HasSnr: true, Source: "nodedb", Heard: heard}
+}
+
+// nodes returns every node with a link, ordered by node number
+func (g *meshGraph) nodes() []uint32 {
+	seen := make(map[uint32]bool)
+	for key := range g.links {
+		seen[key[0]] = true
+		seen[key[1]] = true
+	}
+
+	nodes := make([]uint32, 0, len(seen))
+	for num := range seen {
+		nodes = append(nodes, num)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	return nodes
+}
+
+// cutNodes returns the nodes that would split the mesh in two if they went offline, treating every link
+// as usable in both directions
+func (g *meshGraph) cutNodes() []uint32 {
+	neighbors := make(map[uint32]map[uint32]bool)
+	for key := range g.links {
+		for _, pair := range [][2]uint32{key, {key[1], key[0]}} {
+			if neighbors[pair[0]] == nil {
+				neighbors[pair[0]] = make(map[uint32]bool)
+			}
+			neighbors[pair[0]][pair[1]] = true
+		}
+	}
+
+	// Tarjan's algorithm for articulation points
+	depth := make(map[uint32]int)
+	low := make(map[uint32]int)
+	cut := make(map[uint32]bool)
+	var visit func(node uint32, parent uint32, d int)
+	visit = func(node uint32, parent uint32, d int) {
+		depth[node] = d
+		low[node] = d
+		children := 0
+		for neighbor := range neighbors[node] {
+			if _, seen := depth[neighbor]; !seen {
+				children++
+				visit(neighbor, node, d+1)
+				if low[neighbor] < low[node] {
+					low[node] = low[neighbor]
+				}
+				if d > 0 && low[neighbor] >= d {
+					cut[node] = true
+				}
+			} else if neighbor != parent && depth[neighbor] < low[node] {
+				low[node] = depth[neighbor]
+			}
+		}
+		if d == 0 && children > 1 {
+			cut[node] = true
+		}
+	}
+
+	for _, node := range g.nodes() {
+		if _, seen := depth[node]; !seen {
+			visit(node, node, 0)
+		}
+	}
+
+	nodes := make([]uint32, 0, len(cut))
+	for node := range cut {
+		nodes = append(nodes, node)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i] < nodes[j] })
+
+	return nodes
+}
+
+// weakLinkSnr is the SNR below which a link is reported as weak. Links this weak only work at the
+// slower LoRa presets and drop packets at the faster ones
+const weakLinkSnr = -7.5
+
+// nodeName returns the name of a node, or its node ID if the name isn't known
+func nodeName(names map[uint32]string, num uint32) string {
+	if name, ok := names[num]; ok {
+		return name
+	}
+	return nodeID(num)
+}
+
+func writeAdjacencyTable(w io.Writer, g *meshGraph, names map[uint32]string) error {
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "Mesh Topology:\n")
+	fmt.Fprintf(w, "%-100s", "=========================================================================================================================================================================================\n")
+	fmt.Fprintf(w, "| %-25s| ", "Node")
+	fmt.Fprintf(w, "%-25s| ", "Neighbor")
+	fmt.Fprintf(w, "%-13s| ", "SNR")
+	fmt.Fprintf(w, "%-15s| ", "Source")
+	fmt.Fprintf(w, "%-25s", "Heard                    |\n")
+	fmt.Fprintf(w, "%-100s", "-----------------------------------------------------------------------------------------------------------------------------------------------------------------------------------------\n")
+	for _, link := range g.sortedLinks() {
+		snr := "-"
+		if link.HasSnr {
+			snr = fmt.Sprintf("%.2f", link.Snr)
+			if link.Snr < weakLinkSnr {
+				snr += " weak"
+			}
+		}
+		fmt.Fprintf(w, "| %-25s| ", nodeName(names, link.From))
+		fmt.Fprintf(w, "%-25s| ", nodeName(names, link.To))
+		fmt.Fprintf(w, "%-13s| ", snr)
+		fmt.Fprintf(w, "%-15s| ", link.Source)
+		fmt.Fprintf(w, "%-25s|\n", link.Heard.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "%-100s", "=========================================================================================================================================================================================\n")
+
+	cut := g.cutNodes()
+	if len(cut) > 0 {
+		fmt.Fprintf(w, "\nSingle points of failure:\n")
+		for _, num := range cut {
+			if name, ok := names[num]; ok && name != nodeID(num) {
+				fmt.Fprintf(w, "  %s (%s)\n", name, nodeID(num))
+			} else {
+				fmt.Fprintf(w, "  %s\n", nodeID(num))
+			}
+		}
+	}
+
+	return nil
+}
+
+func writeDOT(w io.Writer, g *meshGraph, names map[uint32]string) error {
+	cut := make(map[uint32]bool)
+	for _, num := range g.cutNodes() {
+		cut[num] = true
+	}
+
+	fmt.Fprintf(w, "digraph mesh {\n")
+	for _, num := range g.nodes() {
+		label := nodeID(num)
+		if name, ok := names[num]; ok && name != label {
+			label = name + "\n" + label
+		}
+		attributes := fmt.Sprintf("label=%q", label)
+		if cut[num] {
+			attributes += ", color=red"
+		}
+		fmt.Fprintf(w, "  %q [%s];\n", nodeID(num), attributes)
+	}
+	for _, link := range g.sortedLinks() {
+		attributes := "style=dashed"
+		if link.HasSnr {
+			attributes = fmt.Sprintf("label=\"%.1f dB\", weight=%d", link.Snr, snrWeight(link.Snr))
+			if link.Snr < weakLinkSnr {
+				attributes += ", color=red"
+			}
+		}
+		fmt.Fprintf(w, "  %q -> %q [%s];\n", nodeID(link.From), nodeID(link.To), attributes)
+	}
+	fmt.Fprintf(w, "}\n")
+
+	return nil
+}
+
+// snrWeight turns an SNR into a positive edge weight that's higher for stronger links
+func snrWeight(snr float32) int {
+	weight := int(snr) + 21
+	if weight < 1 {
+		weight = 1
+	}
+	return weight
+}
+
+func writeGraphML(w io.Writer, g *meshGraph, names map[uint32]string) error {
+	type data struct {
+		Key   string `xml:"key,attr"`
+		Value string `xml:",chardata"`
+	}
+	type node struct {
+		ID   string `xml:"id,attr"`
+		Data []data `xml:"data"`
+	}
+	type edge struct {
+		Source string `xml:"source,attr"`
+		Target string `xml:"target,attr"`
+		Data   []data `xml:"data"`
+	}
+	type key struct {
+		ID   string `xml:"id,attr"`
+		For  string `xml:"for,attr"`
+		Name string `xml:"attr.name,attr"`
+		Type string `xml:"attr.type,attr"`
+	}
+
+	cut := make(map[uint32]bool)
+	for _, num := range g.cutNodes() {
+		cut[num] = true
+	}
+
+	nodes := make([]node, 0)
+	for _, num := range g.nodes() {
+		nodes = append(nodes, node{ID: nodeID(num), Data: []data{
+			{Key: "name", Value: nodeName(names, num)},
+			{Key: "cut", Value: fmt.Sprint(cut[num])},
+		}})
+	}
+
+	edges := make([]edge, 0)
+	for _, link := range g.sortedLinks() {
+		linkData := []data{
+			{Key: "source", Value: link.Source},
+			{Key: "heard", Value: link.Heard.Format(time.RFC3339)},
+		}
+		if link.HasSnr {
+			linkData = append(linkData, data{Key: "snr", Value: fmt.Sprint(link.Snr)}, data{Key: "weight", Value: fmt.Sprint(snrWeight(link.Snr))})
+		}
+		edges = append(edges, edge{Source: nodeID(link.From), Target: nodeID(link.To), Data: linkData})
+	}
+
+	document := struct {
+		XMLName   xml.Name `xml:"graphml"`
+		Namespace string   `xml:"xmlns,attr"`
+		Keys      []key    `xml:"key"`
+		Graph     struct {
+			ID          string `xml:"id,attr"`
+			EdgeDefault string `xml:"edgedefault,attr"`
+			Nodes       []node `xml:"node"`
+			Edges       []edge `xml:"edge"`
+		} `xml:"graph"`
+	}{
+		Namespace: "http://graphml.graphdrawing.org/xmlns",
+		Keys: []key{
+			{ID: "name", For: "node", Name: "name", Type: "string"},
+			{ID: "cut", For: "node", Name: "single_point_of_failure", Type: "boolean"},
+			{ID: "snr", For: "edge", Name: "snr", Type: "double"},
+			{ID: "weight", For: "edge", Name: "weight", Type: "int"},
+			{ID: "source", For: "edge", Name: "source", Type: "string"},
+			{ID: "heard", For: "edge", Name: "heard", Type: "string"},
+		},
+	}
+	document.Graph.ID = "mesh"
+	document.Graph.EdgeDefault = "directed"
+	document.Graph.Nodes = nodes
+	document.Graph.Edges = edges
+
+	return writeXML(w, document)
+}
@@ -0,0 +1,477 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lmatte7/gomesh"
+	"github.com/lmatte7/gomesh/github.com/meshtastic/gomeshproto"
+	"github.com/urfave/cli/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// savedWaypoint is a waypoint kept in the waypoint store
+type savedWaypoint struct {
+	ID          uint32    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Icon        string    `json:"icon,omitempty"`
+	Sym         string    `json:"sym,omitempty"`
+	Lat         float64   `json:"lat"`
+	Lon         float64   `json:"lon"`
+	Expire      uint32    `json:"expire,omitempty"`
+	LockedTo    uint32    `json:"locked_to,omitempty"`
+	From        uint32    `json:"from,omitempty"`
+	Updated     time.Time `json:"updated"`
+}
+
+// gpxWaypoint is a wpt element in a GPX file
+type gpxWaypoint struct {
+	Lat        float64               `xml:"lat,attr"`
+	Lon        float64               `xml:"lon,attr"`
+	Time       string                `xml:"time,omitempty"`
+	Name       string                `xml:"name,omitempty"`
+	Desc       string                `xml:"desc,omitempty"`
+	Sym        string                `xml:"sym,omitempty"`
+	Extensions *gpxWaypointExtension `xml:"extensions>waypoint"`
+}
+
+// gpxWaypointExtension holds the waypoint details GPX has no elements for
+type gpxWaypointExtension struct {
+	XMLName  xml.Name `xml:"https://meshtastic.org/gpx waypoint"`
+	ID       uint32   `xml:"id,omitempty"`
+	Expire   uint32   `xml:"expire,omitempty"`
+	LockedTo uint32   `xml:"locked_to,omitempty"`
+	Sym      string   `xml:"sym,omitempty"`
+}
+
+func addWaypoint(c *cli.Context) error {
+
+	position, err := positionFromFlags(c)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	waypoint := &gomeshproto.Waypoint{
+		Id:          uint32(c.Uint("id")),
+		LatitudeI:   position.LatitudeI,
+		LongitudeI:  position.LongitudeI,
+		Name:        c.String("name"),
+		Description: c.String("description"),
+	}
+	if waypoint.Id == 0 {
+		waypoint.Id = newPacketID()
+	}
+	if c.String("icon") != "" {
+		// Waypoints hold a single character, so emoji made of several such as flags can't be sent
+		icon, ok := symbolIcon(c.String("icon"))
+		if !ok {
+			return cli.Exit(fmt.Sprintf("--icon must be a single emoji, %q can't be sent as a waypoint icon", c.String("icon")), 0)
+		}
+		waypoint.Icon = icon
+	}
+	if c.Duration("expire") > 0 {
+		waypoint.Expire = uint32(time.Now().Add(c.Duration("expire")).Unix())
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	nodeNum, err := getNodeNum(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	if c.Bool("locked") {
+		waypoint.LockedTo = nodeNum
+	}
+
+	if err := sendWaypoint(c, radio, nodeNum, waypoint, ""); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("Sent waypoint %d\n", waypoint.Id)
+	return nil
+}
+
+func deleteWaypoint(c *cli.Context) error {
+
+	if c.NArg() != 1 {
+		return cli.Exit("Waypoint ID is required", 0)
+	}
+	id, err := strconv.ParseUint(c.Args().First(), 0, 32)
+	if err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid waypoint ID %s", c.Args().First()), 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	nodeNum, err := getNodeNum(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	// Apps remove a waypoint when they receive it again with an expiry in the past
+	if err := sendWaypoint(c, radio, nodeNum, &gomeshproto.Waypoint{Id: uint32(id), Expire: 1}, ""); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	fmt.Printf("Deleted waypoint %d\n", id)
+	return nil
+}
+
+func listWaypoints(c *cli.Context) error {
+
+	path, err := waypointStorePath(c)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	if c.Duration("listen") > 0 {
+		radio := getRadio(c)
+		defer radio.Close()
+
+		fmt.Fprintf(os.Stderr, "Listening for waypoints for %s\n", c.Duration("listen"))
+		err := listenPackets(radio, time.Now().Add(c.Duration("listen")), func(packet *gomeshproto.MeshPacket) error {
+			if packet.GetDecoded().GetPortnum() != gomeshproto.PortNum_WAYPOINT_APP {
+				return nil
+			}
+			waypoint := gomeshproto.Waypoint{}
+			if err := unmarshalPayload(packet, &waypoint); err != nil {
+				return nil
+			}
+			return storeWaypoint(path, &waypoint, packet.From, "")
+		})
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+	}
+
+	waypoints, err := loadWaypoints(path)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	printWaypoints(waypoints)
+	return nil
+}
+
+func importWaypoints(c *cli.Context) error {
+
+	if c.NArg() != 1 {
+		return cli.Exit("GPX file is required", 0)
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	defer f.Close()
+
+	document := struct {
+		Waypoints []gpxWaypoint `xml:"wpt"`
+	}{}
+	if err := xml.NewDecoder(f).Decode(&document); err != nil {
+		return cli.Exit(fmt.Sprintf("Invalid GPX file: %v", err), 0)
+	}
+	if len(document.Waypoints) == 0 {
+		return cli.Exit("No waypoints found in the GPX file", 0)
+	}
+
+	radio := getRadio(c)
+	defer radio.Close()
+
+	nodeNum, err := getNodeNum(radio)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	for i, point := range document.Waypoints {
+		waypoint := &gomeshproto.Waypoint{
+			Id:          newPacketID(),
+			LatitudeI:   degreesToInt(point.Lat),
+			LongitudeI:  degreesToInt(point.Lon),
+			Name:        point.Name,
+			Description: point.Desc,
+		}
+		// Symbols that aren't a single emoji, such as "Flag, Blue", can't be sent as the icon, so they're
+		// only kept in the waypoint store
+		sym := ""
+		if icon, ok := symbolIcon(point.Sym); ok {
+			waypoint.Icon = icon
+		} else {
+			sym = point.Sym
+		}
+		if point.Extensions != nil {
+			if point.Extensions.ID != 0 {
+				waypoint.Id = point.Extensions.ID
+			}
+			waypoint.Expire = point.Extensions.Expire
+			waypoint.LockedTo = point.Extensions.LockedTo
+			if point.Extensions.Sym != "" {
+				sym = point.Extensions.Sym
+			}
+		}
+		if c.Duration("expire") > 0 {
+			waypoint.Expire = uint32(time.Now().Add(c.Duration("expire")).Unix())
+		}
+		if waypoint.Expire > 0 && int64(waypoint.Expire) < time.Now().Unix() {
+			fmt.Printf("Skipping expired waypoint %s\n", point.Name)
+			continue
+		}
+
+		// Give the radio time to send each waypoint rather than filling its queue
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		if err := sendWaypoint(c, radio, nodeNum, waypoint, sym); err != nil {
+			return cli.Exit(err, 0)
+		}
+		fmt.Printf("Sent waypoint %d %s\n", waypoint.Id, waypoint.Name)
+	}
+
+	return nil
+}
+
+func exportWaypoints(c *cli.Context) error {
+
+	path, err := waypointStorePath(c)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+	waypoints, err := loadWaypoints(path)
+	if err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	out := io.Writer(os.Stdout)
+	if c.String("out") != "" {
+		f, err := os.Create(c.String("out"))
+		if err != nil {
+			return cli.Exit(err, 0)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := writeWaypointGPX(out, waypoints); err != nil {
+		return cli.Exit(err, 0)
+	}
+
+	return nil
+}
+
+// symbolIcon returns the icon for a GPX symbol that's a single emoji or other non-ASCII character. Named
+// symbols such as "Flag, Blue" aren't icons
+func symbolIcon(sym string) (uint32, bool) {
+	sym = strings.TrimSuffix(strings.TrimSpace(sym), "\uFE0F")
+	icon, size := utf8.DecodeRuneInString(sym)
+	if icon < utf8.RuneSelf || icon == utf8.RuneError || size != len(sym) {
+		return 0, false
+	}
+
+	return uint32(icon), true
+}
+
+// sendWaypoint sends a waypoint to the mesh and records it in the waypoint store as coming from nodeNum,
+// along with its GPX symbol if it has one that isn't the icon
+func sendWaypoint(c *cli.Context, r gomesh.Radio, nodeNum uint32, waypoint *gomeshproto.Waypoint, sym string) error {
+	to := uint32(broadcastNum)
+	if c.IsSet("to") {
+		var err error
+		to, err = parseNodeID(c.String("to"))
+		if err != nil {
+			return err
+		}
+	}
+
+	payload, err := proto.Marshal(waypoint)
+	if err != nil {
+		return err
+	}
+
+	err = sendPacket(r, &gomeshproto.MeshPacket{
+		To:      to,
+		Id:      newPacketID(),
+		Channel: uint32(c.Int64("channel")),
+		PayloadVariant: &gomeshproto.MeshPacket_Decoded{
+			Decoded: &gomeshproto.Data{
+				Payload: payload,
+				Portnum: gomeshproto.PortNum_WAYPOINT_APP,
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	path, err := waypointStorePath(c)
+	if err != nil {
+		return err
+	}
+	return storeWaypoint(path, waypoint, nodeNum, sym)
+}
+
+// waypointStorePath returns the path of the waypoint store, set with --store or kept in the user's
+// config directory
+func waypointStorePath(c *cli.Context) (string, error) {
+	if c.String("store") != "" {
+		return c.String("store"), nil
+	}
+	return dataPath("waypoints.json")
+}
+
+// loadWaypoints reads the waypoint store, dropping any waypoints that have expired
+func loadWaypoints(path string) ([]*savedWaypoint, error) {
+	waypoints := make([]*savedWaypoint, 0)
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return waypoints, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &waypoints); err != nil {
+		return nil, fmt.Errorf("invalid waypoint store %s: %v", path, err)
+	}
+
+	now := time.Now().Unix()
+	current := make([]*savedWaypoint, 0, len(waypoints))
+	for _, waypoint := range waypoints {
+		if waypoint.Expire == 0 || int64(waypoint.Expire) > now {
+			current = append(current, waypoint)
+		}
+	}
+
+	return current, nil
+}
+
+// storeWaypoint adds or replaces a waypoint in the waypoint store. Waypoints that have expired are
+// removed, which is how waypoints are deleted. The mesh doesn't carry GPX symbols, so a waypoint without
+// an icon keeps the symbol it was stored with unless a new one is given
+func storeWaypoint(path string, waypoint *gomeshproto.Waypoint, from uint32, sym string) error {
+	waypoints, err := loadWaypoints(path)
+	if err != nil {
+		return err
+	}
+
+	kept := make([]*savedWaypoint, 0, len(waypoints)+1)
+	for _, saved := range waypoints {
+		if saved.ID != waypoint.Id {
+			kept = append(kept, saved)
+		} else if sym == "" && waypoint.Icon == 0 {
+			sym = saved.Sym
+		}
+	}
+
+	if waypoint.Expire == 0 || int64(waypoint.Expire) > time.Now().Unix() {
+		saved := &savedWaypoint{
+			ID:          waypoint.Id,
+			Name:        waypoint.Name,
+			Description: waypoint.Description,
+			Lat:         float64(waypoint.LatitudeI) / 1e7,
+			Lon:         float64(waypoint.LongitudeI) / 1e7,
+			Expire:      waypoint.Expire,
+			LockedTo:    waypoint.LockedTo,
+			From:        from,
+			Updated:     time.Now().UTC().Truncate(time.Second),
+		}
+		if waypoint.Icon != 0 {
+			saved.Icon = string(rune(waypoint.Icon))
+		} else {
+			saved.Sym = sym
+		}
+		kept = append(kept, saved)
+	}
+	sort.Slice(kept, func(i, j int) bool { return kept[i].ID < kept[j].ID })
+
+	data, err := json.MarshalIndent(kept, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+func printWaypoints(waypoints []*savedWaypoint) {
+	fmt.Printf("\n")
+	fmt.Printf("Waypoints:\n")
+	printDoubleDivider()
+	fmt.Printf("| %-12s| ", "ID")
+	fmt.Printf("%-5s| ", "Icon")
+	fmt.Printf("%-25s| ", "Name")
+	fmt.Printf("%-13s| ", "Latitude")
+	fmt.Printf("%-13s| ", "Longitude")
+	fmt.Printf("%-22s| ", "Expires")
+	fmt.Printf("%-12s", "From        |\n")
+	printSingleDivider()
+	for _, waypoint := range waypoints {
+		expires := "Never"
+		if waypoint.Expire > 0 {
+			expires = time.Unix(int64(waypoint.Expire), 0).Format("2006-01-02 15:04:05")
+		}
+		from := "-"
+		if waypoint.From != 0 {
+			from = nodeID(waypoint.From)
+		}
+		fmt.Printf("| %-12d| ", waypoint.ID)
+		fmt.Printf("%-5s| ", waypoint.Icon)
+		fmt.Printf("%-25s| ", waypoint.Name)
+		fmt.Printf("%-13.7f| ", waypoint.Lat)
+		fmt.Printf("%-13.7f| ", waypoint.Lon)
+		fmt.Printf("%-22s| ", expires)
+		fmt.Printf("%-12s|\n", from)
+	}
+	printDoubleDivider()
+}
+
+// writeWaypointGPX writes waypoints as GPX waypoints. The waypoint ID, expiry, lock and any symbol that
+// isn't an icon are kept in an extension so the file can be imported again
+func writeWaypointGPX(w io.Writer, waypoints []*savedWaypoint) error {
+	points := make([]gpxWaypoint, 0, len(waypoints))
+	for _, waypoint := range waypoints {
+		point := gpxWaypoint{
+			Lat:  waypoint.Lat,
+			Lon:  waypoint.Lon,
+			Time: waypoint.Updated.Format(time.RFC3339),
+			Name: waypoint.Name,
+			Desc: waypoint.Description,
+			Sym:  waypoint.Icon,
+			Extensions: &gpxWaypointExtension{
+				ID:       waypoint.ID,
+				Expire:   waypoint.Expire,
+				LockedTo: waypoint.LockedTo,
+				Sym:      waypoint.Sym,
+			},
+		}
+		if point.Sym == "" {
+			point.Sym = waypoint.Sym
+		}
+		points = append(points, point)
+	}
+
+	document := struct {
+		XMLName   xml.Name      `xml:"gpx"`
+		Namespace string        `xml:"xmlns,attr"`
+		Version   string        `xml:"version,attr"`
+		Creator   string        `xml:"creator,attr"`
+		Waypoints []gpxWaypoint `xml:"wpt"`
+	}{
+		Namespace: "http://www.topografix.com/GPX/1/1",
+		Version:   "1.1",
+		Creator:   "meshtastic-go",
+		Waypoints: points,
+	}
+
+	return writeXML(w, document)
+}
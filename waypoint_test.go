@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestSymbolIcon(t *testing.T) {
+	tests := []struct {
+		sym  string
+		icon uint32
+		ok   bool
+	}{
+		{"🚩", 0x1f6a9, true},
+		{" 🚩 ", 0x1f6a9, true},
+		{"⚠️", 0x26a0, true},
+		{"é", 0xe9, true},
+		{"", 0, false},
+		{"F", 0, false},
+		{"Flag, Blue", 0, false},
+		{"🚩🚩", 0, false},
+		{"🇳🇿", 0, false},
+		{"👍🏽", 0, false},
+		{"\xff", 0, false},
+	}
+
+	for _, test := range tests {
+		icon, ok := symbolIcon(test.sym)
+		if icon != test.icon || ok != test.ok {
+			t.Errorf("%q gave icon %#x %v, want %#x %v", test.sym, icon, ok, test.icon, test.ok)
+		}
+	}
+}
@@ -0,0 +1,432 @@
+"use strict";
+
+const TILE_SIZE = 256;
+
+const state = {
+  nodeNum: 0,
+  nodes: new Map(),
+  links: [],
+  tiles: "",
+  zoom: 3,
+  center: { x: 0.5, y: 0.5 },
+  selected: null,
+  telemetry: [],
+};
+
+const mapElement = document.getElementById("map");
+const tilesElement = document.getElementById("tiles");
+const linksElement = document.getElementById("links");
+const markersElement = document.getElementById("markers");
+
+// project converts a position to web mercator coordinates between 0 and 1
+function project(lat, lon) {
+  const sin = Math.sin((lat * Math.PI) / 180);
+  return {
+    x: (lon + 180) / 360,
+    y: 0.5 - Math.log((1 + sin) / (1 - sin)) / (4 * Math.PI),
+  };
+}
+
+// toScreen converts web mercator coordinates to a pixel position on the map
+function toScreen(point) {
+  const scale = TILE_SIZE * Math.pow(2, state.zoom);
+  return {
+    x: (point.x - state.center.x) * scale + mapElement.clientWidth / 2,
+    y: (point.y - state.center.y) * scale + mapElement.clientHeight / 2,
+  };
+}
+
+function positioned() {
+  return [...state.nodes.values()].filter((node) => node.lat !== undefined);
+}
+
+function nodeName(num) {
+  const node = state.nodes.get(num);
+  return node ? node.short_name || node.name : "!" + num.toString(16).padStart(8, "0");
+}
+
+function drawTiles() {
+  const count = Math.pow(2, state.zoom);
+  const scale = TILE_SIZE * count;
+  const left = state.center.x * scale - mapElement.clientWidth / 2;
+  const top = state.center.y * scale - mapElement.clientHeight / 2;
+
+  const fragment = document.createDocumentFragment();
+  for (let ty = Math.floor(top / TILE_SIZE); ty * TILE_SIZE < top + mapElement.clientHeight; ty++) {
+    if (ty < 0 || ty >= count) {
+      continue;
+    }
+    for (let tx = Math.floor(left / TILE_SIZE); tx * TILE_SIZE < left + mapElement.clientWidth; tx++) {
+      const img = document.createElement("img");
+      const wrapped = ((tx % count) + count) % count;
+      img.src = state.tiles.replace("{z}", state.zoom).replace("{x}", wrapped).replace("{y}", ty);
+      img.style.left = tx * TILE_SIZE - left + "px";
+      img.style.top = ty * TILE_SIZE - top + "px";
+      img.alt = "";
+      // Leave the grid background showing when tiles can't be loaded
+      img.onerror = () => img.remove();
+      fragment.appendChild(img);
+    }
+  }
+  tilesElement.replaceChildren(fragment);
+}
+
+function snrColor(snr) {
+  // Map SNR from -20dB (red) to 10dB (green)
+  const ratio = Math.min(Math.max((snr + 20) / 30, 0), 1);
+  return `hsl(${Math.round(ratio * 120)}, 80%, 40%)`;
+}
+
+function drawLinks() {
+  const fragment = document.createDocumentFragment();
+  for (const link of state.links) {
+    const from = state.nodes.get(link.from);
+    const to = state.nodes.get(link.to);
+    if (!from || !to || from.lat === undefined || to.lat === undefined) {
+      continue;
+    }
+    const a = toScreen(project(from.lat, from.lon));
+    const b = toScreen(project(to.lat, to.lon));
+    const line = document.createElementNS("http://www.w3.org/2000/svg", "line");
+    line.setAttribute("x1", a.x);
+    line.setAttribute("y1", a.y);
+    line.setAttribute("x2", b.x);
+    line.setAttribute("y2", b.y);
+    if (link.has_snr) {
+      line.setAttribute("stroke", snrColor(link.snr));
+    } else {
+      line.classList.add("traceroute");
+    }
+    const title = document.createElementNS("http://www.w3.org/2000/svg", "title");
+    title.textContent = `${nodeName(link.from)} → ${nodeName(link.to)}` + (link.has_snr ? ` ${link.snr.toFixed(1)} dB` : " (traceroute)");
+    line.appendChild(title);
+    fragment.appendChild(line);
+  }
+  linksElement.replaceChildren(fragment);
+}
+
+function drawMarkers() {
+  const fragment = document.createDocumentFragment();
+  for (const node of positioned()) {
+    const point = toScreen(project(node.lat, node.lon));
+    const marker = document.createElement("div");
+    marker.className = "marker";
+    marker.classList.toggle("self", node.num === state.nodeNum);
+    marker.classList.toggle("selected", node.num === state.selected);
+    marker.style.left = point.x + "px";
+    marker.style.top = point.y + "px";
+    marker.textContent = node.short_name || node.id;
+    marker.title = `${node.name} (${node.id})`;
+    marker.addEventListener("click", () => selectNode(node.num));
+    fragment.appendChild(marker);
+  }
+  markersElement.replaceChildren(fragment);
+}
+
+function drawMap() {
+  drawTiles();
+  drawLinks();
+  drawMarkers();
+}
+
+// fitNodes zooms the map to show every node with a position
+function fitNodes() {
+  const points = positioned().map((node) => project(node.lat, node.lon));
+  if (points.length === 0) {
+    drawMap();
+    return;
+  }
+
+  const minX = Math.min(...points.map((p) => p.x));
+  const maxX = Math.max(...points.map((p) => p.x));
+  const minY = Math.min(...points.map((p) => p.y));
+  const maxY = Math.max(...points.map((p) => p.y));
+  state.center = { x: (minX + maxX) / 2, y: (minY + maxY) / 2 };
+
+  state.zoom = 16;
+  while (state.zoom > 1) {
+    const scale = TILE_SIZE * Math.pow(2, state.zoom);
+    if ((maxX - minX) * scale < mapElement.clientWidth - 80 && (maxY - minY) * scale < mapElement.clientHeight - 80) {
+      break;
+    }
+    state.zoom--;
+  }
+  drawMap();
+}
+
+function setZoom(zoom) {
+  state.zoom = Math.min(Math.max(zoom, 1), 19);
+  drawMap();
+}
+
+function formatAge(seconds) {
+  if (!seconds) {
+    return "";
+  }
+  const age = Date.now() / 1000 - seconds;
+  if (age < 60) {
+    return "now";
+  }
+  if (age < 3600) {
+    return Math.round(age / 60) + "m ago";
+  }
+  if (age < 86400) {
+    return Math.round(age / 3600) + "h ago";
+  }
+  return Math.round(age / 86400) + "d ago";
+}
+
+function drawNodes() {
+  const tbody = document.querySelector("#nodes tbody");
+  const nodes = [...state.nodes.values()].sort((a, b) => b.last_heard - a.last_heard);
+  tbody.replaceChildren(
+    ...nodes.map((node) => {
+      const row = document.createElement("tr");
+      row.classList.toggle("selected", node.num === state.selected);
+      for (const value of [
+        node.name,
+        node.id,
+        node.battery !== undefined ? node.battery + "%" : "",
+        node.snr ? node.snr.toFixed(1) : "",
+        node.hops_away,
+        formatAge(node.last_heard),
+      ]) {
+        const cell = document.createElement("td");
+        cell.textContent = value;
+        row.appendChild(cell);
+      }
+      row.addEventListener("click", () => selectNode(node.num));
+      return row;
+    })
+  );
+}
+
+function addMessage(message) {
+  const parent = message.reply_id ? document.querySelector(`#messages li[data-id="${message.reply_id}"]`) : null;
+  if (parent && message.emoji) {
+    const reactions = parent.querySelector(":scope > .reactions");
+    let badge = [...reactions.children].find((child) => child.dataset.emoji === message.text);
+    if (!badge) {
+      badge = document.createElement("span");
+      badge.dataset.emoji = message.text;
+      badge.dataset.count = 0;
+      reactions.append(badge);
+    }
+    badge.dataset.count = Number(badge.dataset.count) + 1;
+    badge.textContent = `${message.text} ${badge.dataset.count}`;
+    return;
+  }
+
+  const item = document.createElement("li");
+  item.dataset.id = message.id;
+  const meta = document.createElement("div");
+  meta.className = "meta";
+  const to = message.to === 0xffffffff ? `channel ${message.channel}` : nodeName(message.to);
+  meta.textContent = `${new Date(message.time).toLocaleTimeString()} ${nodeName(message.from)} → ${to}`;
+  const text = document.createElement("div");
+  text.textContent = message.text;
+  if (message.reply_id && !parent) {
+    text.textContent = message.emoji ? `${message.text} reaction to ${message.reply_id}` : `↳ reply to ${message.reply_id}: ${message.text}`;
+  }
+  const reactions = document.createElement("div");
+  reactions.className = "reactions";
+  const replies = document.createElement("ul");
+  replies.className = "replies";
+  item.append(meta, text, reactions, replies);
+
+  if (parent) {
+    parent.querySelector(":scope > .replies").append(item);
+    return;
+  }
+
+  const list = document.getElementById("messages");
+  list.prepend(item);
+  while (list.children.length > 200) {
+    list.lastChild.remove();
+  }
+}
+
+const series = [
+  { key: "battery_level", label: "Battery %", color: "#1f8a4c", min: 0, max: 100 },
+  { key: "channel_utilization", label: "Channel util %", color: "#3366cc", min: 0, max: 100 },
+  { key: "air_util_tx", label: "Air util TX %", color: "#9c27b0", min: 0, max: 100 },
+  { key: "voltage", label: "Voltage", color: "#e07b00", min: 3, max: 4.5 },
+  { key: "temperature", label: "Temperature °C", color: "#d32f2f", min: -20, max: 50 },
+];
+
+function drawChart() {
+  const canvas = document.getElementById("chart");
+  const context = canvas.getContext("2d");
+  context.clearRect(0, 0, canvas.width, canvas.height);
+
+  const points = state.telemetry;
+  const legend = document.getElementById("legend");
+  legend.replaceChildren();
+  if (points.length === 0) {
+    context.fillStyle = "#777";
+    context.fillText("No telemetry received yet", 10, 20);
+    return;
+  }
+
+  const times = points.map((point) => new Date(point.time).getTime());
+  const start = Math.min(...times);
+  const span = Math.max(Math.max(...times) - start, 1);
+
+  for (const line of series) {
+    const values = points.filter((point) => point[line.key] !== undefined);
+    if (values.length === 0) {
+      continue;
+    }
+
+    context.strokeStyle = line.color;
+    context.lineWidth = 2;
+    context.beginPath();
+    values.forEach((point, i) => {
+      const x = ((new Date(point.time).getTime() - start) / span) * (canvas.width - 20) + 10;
+      const ratio = (point[line.key] - line.min) / (line.max - line.min);
+      const y = canvas.height - 10 - Math.min(Math.max(ratio, 0), 1) * (canvas.height - 20);
+      if (i === 0) {
+        context.moveTo(x, y);
+      } else {
+        context.lineTo(x, y);
+      }
+    });
+    context.stroke();
+
+    const label = document.createElement("span");
+    label.style.setProperty("--color", line.color);
+    label.textContent = `${line.label}: ${Number(values[values.length - 1][line.key]).toFixed(line.max > 10 ? 0 : 2)}`;
+    legend.appendChild(label);
+  }
+}
+
+async function selectNode(num) {
+  state.selected = num;
+  document.getElementById("telemetry").hidden = false;
+  document.getElementById("telemetry-node").textContent = nodeName(num);
+  drawNodes();
+  drawMarkers();
+
+  const response = await fetch(`telemetry?node=${num}`);
+  state.telemetry = response.ok ? await response.json() : [];
+  drawChart();
+}
+
+function updateNode(node) {
+  state.nodes.set(node.num, node);
+}
+
+let redraw = null;
+
+// scheduleRedraw batches redraws when many events arrive at once
+function scheduleRedraw() {
+  if (redraw) {
+    return;
+  }
+  redraw = setTimeout(() => {
+    redraw = null;
+    drawNodes();
+    drawLinks();
+    drawMarkers();
+  }, 250);
+}
+
+function connect() {
+  const status = document.getElementById("status");
+  const events = new EventSource("events");
+
+  events.onopen = () => {
+    status.textContent = "Connected";
+    status.className = "connected";
+  };
+  events.onerror = () => {
+    status.textContent = "Reconnecting";
+    status.className = "";
+  };
+  events.addEventListener("node", (event) => {
+    updateNode(JSON.parse(event.data));
+    scheduleRedraw();
+  });
+  events.addEventListener("links", (event) => {
+    state.links = JSON.parse(event.data);
+    scheduleRedraw();
+  });
+  events.addEventListener("message", (event) => {
+    addMessage(JSON.parse(event.data));
+  });
+  events.addEventListener("telemetry", (event) => {
+    const point = JSON.parse(event.data);
+    if (point.node === state.selected) {
+      state.telemetry.push(point);
+      drawChart();
+    }
+  });
+}
+
+async function load() {
+  const response = await fetch("state");
+  const data = await response.json();
+
+  state.nodeNum = data.node_num;
+  state.tiles = data.tiles;
+  state.links = data.links;
+  data.nodes.forEach(updateNode);
+  data.messages.forEach(addMessage);
+
+  drawNodes();
+  fitNodes();
+  connect();
+}
+
+let drag = null;
+
+mapElement.addEventListener("mousedown", (event) => {
+  drag = { x: event.clientX, y: event.clientY, center: { ...state.center } };
+  mapElement.classList.add("dragging");
+});
+
+window.addEventListener("mousemove", (event) => {
+  if (!drag) {
+    return;
+  }
+  const scale = TILE_SIZE * Math.pow(2, state.zoom);
+  state.center = {
+    x: drag.center.x - (event.clientX - drag.x) / scale,
+    y: Math.min(Math.max(drag.center.y - (event.clientY - drag.y) / scale, 0), 1),
+  };
+  drawMap();
+});
+
+window.addEventListener("mouseup", () => {
+  drag = null;
+  mapElement.classList.remove("dragging");
+});
+
+mapElement.addEventListener("wheel", (event) => {
+  event.preventDefault();
+  setZoom(state.zoom + (event.deltaY < 0 ? 1 : -1));
+});
+
+document.getElementById("send").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const text = document.getElementById("send-text");
+  const response = await fetch("messages", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ text: text.value, channel: Number(document.getElementById("send-channel").value) }),
+  });
+  if (response.ok) {
+    text.value = "";
+  } else {
+    alert((await response.json()).error);
+  }
+});
+
+document.getElementById("zoom").addEventListener("mousedown", (event) => event.stopPropagation());
+document.getElementById("zoom-in").addEventListener("click", () => setZoom(state.zoom + 1));
+document.getElementById("zoom-out").addEventListener("click", () => setZoom(state.zoom - 1));
+document.getElementById("zoom-fit").addEventListener("click", fitNodes);
+markersElement.addEventListener("mousedown", (event) => event.stopPropagation());
+window.addEventListener("resize", drawMap);
+
+load();